@@ -6,14 +6,25 @@ package main
 
 import (
 	"fmt"
+	"slices"
+
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 type App struct {
-	MotionManager *MotionManager
-	AnimIndex     int
-	AnimNames     []string
+	Scene      *Scene
+	Reloaders  []*HotReloader // 每个 model3.json 一个
+	Current    int            // 键盘操作的模型
+	AnimIndex  int
+	AnimNames  []string
+	ExpIndex   int
+	ExpNames   []string
+	Recorder   *Recorder // 录制中的模型，再次按 V 时保存
+	Clock      *Clock
+	Move       Vector2 // WASD 的移动方向，按固定步长移动，绘制时按 Clock.Alpha 插值
+	PrevOrigin Vector2 // 上一个固定步长时当前模型的位置
 }
 
 var (
@@ -22,10 +33,67 @@ var (
 )
 
 func (a *App) Update() error {
-	a.MotionManager.Update(1.0 / float64(ebiten.TPS()))
+	delta, steps := a.Clock.Tick()
+	for _, reloader := range a.Reloaders { // 轮询文件使用实际时间，不受时间缩放影响
+		reloader.Update(a.Clock.RealDelta)
+	}
+	a.Scene.Update(delta)
+	if a.Recorder != nil {
+		a.Recorder.Record(delta)
+	}
+	if len(a.Scene.Instances) == 0 { // 还在加载中
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		a.Select((a.Current + 1) % len(a.Scene.Instances))
+	}
+	instance := a.Scene.Instances[a.Current]
+	motionManager := instance.MotionManager
 	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
 		a.AnimIndex = (a.AnimIndex + 1) % len(a.AnimNames)
-		a.MotionManager.PlayMotion(a.AnimNames[a.AnimIndex], true)
+		motionManager.PlayMotion(a.AnimNames[a.AnimIndex], LoopAuto)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) && len(a.ExpNames) > 0 {
+		a.ExpIndex = (a.ExpIndex + 1) % len(a.ExpNames)
+		motionManager.PlayExpression(a.ExpNames[a.ExpIndex])
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyV) { // 录制当前模型的参数变化
+		if a.Recorder == nil {
+			a.Recorder = NewRecorder(motionManager.Model, 0.002)
+		} else {
+			a.Recorder.Save("record.motion3.json")
+			fmt.Println("save record.motion3.json")
+			a.Recorder = nil
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) { // 暂停 倒放 左右方向键拖动进度
+		motionManager.Paused = !motionManager.Paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		motionManager.SetSpeed(-motionManager.Speed)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) { // 整体时间缩放，T 切换确定模式
+		a.Clock.SetTimeScale(a.Clock.TimeScale / 2)
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyEqual) {
+		a.Clock.SetTimeScale(a.Clock.TimeScale * 2)
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyT) {
+		a.Clock.SetDeterministic(!a.Clock.Deterministic)
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyLeft) {
+		motionManager.Seek(motionManager.Timer - a.Clock.RealDelta)
+	} else if ebiten.IsKeyPressed(ebiten.KeyRight) {
+		motionManager.Seek(motionManager.Timer + a.Clock.RealDelta)
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) { // 打印点击到的部件路径
+		currX, currY := ebiten.CursorPosition()
+		x, y := motionManager.ScreenToModel(float32(currX), float32(currY))
+		if drawable := motionManager.Model.GetDrawableAt(x, y); drawable != nil {
+			path := drawable.Id
+			for part := drawable.Part; part != nil; part = part.Parent {
+				path = fmt.Sprintf("%s(%s)/%s", part.Id, part.Name, path)
+			}
+			fmt.Println(path)
+		}
 	}
 	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 		lastX, lastY = ebiten.CursorPosition()
@@ -34,28 +102,89 @@ func (a *App) Update() error {
 		x, y := ebiten.WindowPosition()
 		ebiten.SetWindowPosition(x+currX-lastX, y+currY-lastY)
 	}
+	a.Move = Vector2{}
 	if ebiten.IsKeyPressed(ebiten.KeyW) {
-		Origin.Y -= 20
+		a.Move.Y = -1
 	} else if ebiten.IsKeyPressed(ebiten.KeyS) {
-		Origin.Y += 20
+		a.Move.Y = 1
 	} else if ebiten.IsKeyPressed(ebiten.KeyA) {
-		Origin.X -= 20
+		a.Move.X = -1
 	} else if ebiten.IsKeyPressed(ebiten.KeyD) {
-		Origin.X += 20
+		a.Move.X = 1
 	} else if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
-		fmt.Println(Origin.X, Origin.Y)
+		fmt.Println(instance.Transform.Origin.X, instance.Transform.Origin.Y)
+	}
+	for i := 0; i < steps; i++ {
+		a.FixedUpdate(a.Clock.FixedDelta)
 	}
 	return nil
 }
 
+// 按固定步长推进，移动距离与帧率无关
+func (a *App) FixedUpdate(step float64) {
+	origin := &a.Scene.Instances[a.Current].Transform.Origin
+	a.PrevOrigin = *origin
+	origin.X += a.Move.X * float32(MoveSpeed*step)
+	origin.Y += a.Move.Y * float32(MoveSpeed*step)
+}
+
+func (a *App) Select(index int) {
+	motionManager := a.Scene.Instances[index].MotionManager
+	a.Current = index
+	a.PrevOrigin = a.Scene.Instances[index].Transform.Origin
+	a.AnimIndex, a.AnimNames = 0, motionManager.GetAllMotions()
+	a.ExpIndex, a.ExpNames = -1, motionManager.GetAllExpressions()
+}
+
+// 作为 Scene.AddModelAsync 的回调，加载完成后选中并监听文件变化
+func (a *App) OnLoad(instance *Instance, err error) {
+	HandleErr(err)
+	exist := false
+	for _, reloader := range a.Reloaders {
+		exist = exist || reloader.Path == instance.Path
+	}
+	if !exist {
+		a.Reloaders = append(a.Reloaders, NewHotReloader(a.Scene, instance.Path))
+	}
+	a.Select(slices.Index(a.Scene.Instances, instance))
+}
+
 func (a *App) Draw(screen *ebiten.Image) {
-	a.MotionManager.Draw(screen)
+	if len(a.Scene.Instances) > 0 { // 在上一个与当前固定步长的位置之间插值，移动时不会因为步数不同而抖动
+		origin := &a.Scene.Instances[a.Current].Transform.Origin
+		curr := *origin
+		*origin = LerpVector2(a.PrevOrigin, curr, float32(a.Clock.Alpha))
+		a.Scene.Draw(screen)
+		*origin = curr
+	} else {
+		a.Scene.Draw(screen)
+	}
+	msg := ""
+	for _, pending := range a.Scene.Pending { // 加载进度
+		done, total, bytes := pending.Loader.Progress.Get()
+		msg += fmt.Sprintf("loading %s %d/%d %.1fMB\n", pending.Path, done, total, float64(bytes)/1024/1024)
+	}
+	for _, reloader := range a.Reloaders { // 热重载出错时显示在左上角
+		for _, err := range reloader.GetErrs() {
+			msg += err.Error() + "\n"
+		}
+	}
+	if len(msg) > 0 {
+		ebitenutil.DebugPrint(screen, msg)
+	}
 }
 
 func (a *App) Layout(w, h int) (int, int) {
 	return w, h
 }
 
-func NewApp(motionManager *MotionManager) *App {
-	return &App{MotionManager: motionManager, AnimIndex: 0, AnimNames: motionManager.GetAllMotions()}
+func NewApp(scene *Scene) *App {
+	res := &App{Scene: scene, Clock: NewClock(DefaultFixedDelta)}
+	for path := range scene.Models {
+		res.Reloaders = append(res.Reloaders, NewHotReloader(scene, path))
+	}
+	if len(scene.Instances) > 0 {
+		res.Select(0)
+	}
+	return res
 }
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"time"
 
 	"github.com/faiface/beep"
@@ -11,20 +11,21 @@ import (
 )
 
 type AudioPlayer struct {
+	FS         fs.FS
 	Dir        string
 	SampleRate beep.SampleRate
 	Audio      *beep.Ctrl
 }
 
-func NewAudioPlayer(dir string) *AudioPlayer {
-	return &AudioPlayer{Dir: dir}
+func NewAudioPlayer(fsys fs.FS, dir string) *AudioPlayer {
+	return &AudioPlayer{FS: fsys, Dir: dir}
 }
 
 func (p *AudioPlayer) Play(sound string) {
 	if p.Audio != nil {
 		p.Audio.Paused = true
 	}
-	file, err := os.Open(filepath.Join(p.Dir, sound))
+	file, err := p.FS.Open(path.Join(p.Dir, sound))
 	HandleErr(err)
 	streamer, format, err := wav.Decode(file)
 	HandleErr(err)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 更新用的时钟，按实际经过的时间推进，掉帧时动画速度不变
+// 动作使用可变步长，物理等需要稳定性的按固定步长推进，Alpha 为剩余时间的比例，绘制时用于插值
+// 确定模式下每帧固定推进 FixedDelta，用于测试与离线渲染
+type Clock struct {
+	Now           func() time.Time // 默认 time.Now，测试时可以替换
+	Last          time.Time
+	TimeScale     float64 // 时间缩放，0 时相当于暂停
+	Deterministic bool
+	FixedDelta    float64 // 固定步长
+	MaxDelta      float64 // 单帧最多推进的时间，避免卡顿或断点后一次推进太多
+	RealDelta     float64 // 这一帧实际经过的时间，不受 TimeScale 影响，用于轮询文件等
+	Accumulator   float64 // 还不够一个固定步长的时间
+	Alpha         float64 // Accumulator / FixedDelta
+	Time          float64 // 缩放后累计的时间
+	Frame         int
+}
+
+func NewClock(fixedDelta float64) *Clock {
+	Assert(fixedDelta > 0, "fixed delta %v must be positive", fixedDelta)
+	return &Clock{Now: time.Now, TimeScale: 1, FixedDelta: fixedDelta, MaxDelta: MaxFrameDelta}
+}
+
+// 每帧调用一次，返回这一帧缩放后的时间与需要推进的固定步数
+func (c *Clock) Tick() (float64, int) {
+	delta := c.FixedDelta
+	if !c.Deterministic {
+		now := c.Now()
+		if !c.Last.IsZero() { // 第一帧按固定步长算
+			delta = now.Sub(c.Last).Seconds()
+		}
+		c.Last = now
+	}
+	c.RealDelta = Clamp(delta, 0, c.MaxDelta)
+	delta = c.RealDelta * c.TimeScale
+	c.Time += delta
+	c.Frame++
+	c.Accumulator += delta
+	steps := 0
+	for c.Accumulator >= c.FixedDelta {
+		c.Accumulator -= c.FixedDelta
+		steps++
+		if steps == MaxFixedSteps { // 加速播放时也不会一帧推进太多步，追不上的整步丢弃
+			c.Accumulator = math.Mod(c.Accumulator, c.FixedDelta)
+			break
+		}
+	}
+	c.Alpha = c.Accumulator / c.FixedDelta
+	return delta, steps
+}
+
+// 切换确定模式或长时间暂停后调用，下一帧不会把中间的时间算进去
+func (c *Clock) Reset() {
+	c.Last = time.Time{}
+	c.Accumulator, c.Alpha = 0, 0
+}
+
+func (c *Clock) SetTimeScale(scale float64) {
+	c.TimeScale = max(scale, 0)
+}
+
+func (c *Clock) SetDeterministic(deterministic bool) {
+	c.Deterministic = deterministic
+	c.Reset()
+}
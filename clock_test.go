@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 确定模式下与墙上时间无关，每帧固定推进 FixedDelta
+func TestClockDeterministic(t *testing.T) {
+	clock := NewClock(DefaultFixedDelta)
+	clock.Now = func() time.Time {
+		t.Fatal("deterministic clock read wall time")
+		return time.Time{}
+	}
+	clock.SetDeterministic(true)
+	for i := 0; i < 60; i++ {
+		delta, steps := clock.Tick()
+		if delta != DefaultFixedDelta || steps != 1 || clock.Alpha != 0 {
+			t.Fatalf("frame %d: delta %v steps %d alpha %v", i, delta, steps, clock.Alpha)
+		}
+	}
+	if math.Abs(clock.Time-1) > 1e-9 || clock.Frame != 60 {
+		t.Errorf("time %v frame %d", clock.Time, clock.Frame)
+	}
+	// 半速时每两帧一个固定步长，中间的帧 Alpha 为 0.5
+	clock.SetTimeScale(0.5)
+	for i := 0; i < 4; i++ {
+		delta, steps := clock.Tick()
+		if delta != DefaultFixedDelta/2 || steps != i%2 || clock.Alpha != float64(1-i%2)*0.5 {
+			t.Fatalf("frame %d: delta %v steps %d alpha %v", i, delta, steps, clock.Alpha)
+		}
+	}
+	if clock.RealDelta != DefaultFixedDelta {
+		t.Errorf("real delta %v", clock.RealDelta)
+	}
+}
+
+func TestClockRealTime(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := NewClock(0.01)
+	clock.Now = func() time.Time {
+		return now
+	}
+	if delta, steps := clock.Tick(); delta != 0.01 || steps != 1 { // 第一帧按固定步长算
+		t.Fatalf("first frame: delta %v steps %d", delta, steps)
+	}
+	now = now.Add(25 * time.Millisecond)
+	if delta, steps := clock.Tick(); math.Abs(delta-0.025) > 1e-9 || steps != 2 || math.Abs(clock.Alpha-0.5) > 1e-6 {
+		t.Fatalf("delta %v steps %d alpha %v", delta, steps, clock.Alpha)
+	}
+	now = now.Add(10 * time.Second) // 卡顿后最多推进 MaxDelta
+	if delta, _ := clock.Tick(); delta != MaxFrameDelta {
+		t.Fatalf("delta %v", delta)
+	}
+	clock.SetTimeScale(0) // 暂停时实际时间照常推进
+	now = now.Add(100 * time.Millisecond)
+	if delta, steps := clock.Tick(); delta != 0 || steps != 0 || math.Abs(clock.RealDelta-0.1) > 1e-9 {
+		t.Fatalf("paused: delta %v steps %d real %v", delta, steps, clock.RealDelta)
+	}
+}
+
+// 时间缩放很大时每帧最多推进 MaxFixedSteps 步，不足一步的时间保留
+func TestClockMaxSteps(t *testing.T) {
+	clock := NewClock(0.01)
+	clock.SetDeterministic(true)
+	clock.SetTimeScale(20.25)
+	for i := 0; i < 3; i++ {
+		delta, steps := clock.Tick()
+		if math.Abs(delta-0.2025) > 1e-9 || steps != MaxFixedSteps || math.Abs(clock.Alpha-0.25*float64(i+1)) > 1e-6 {
+			t.Fatalf("frame %d: delta %v steps %d alpha %v", i, delta, steps, clock.Alpha)
+		}
+	}
+	if delta, steps := clock.Tick(); steps != MaxFixedSteps || clock.Alpha >= 1 || delta <= 0 {
+		t.Fatalf("delta %v steps %d alpha %v", delta, steps, clock.Alpha)
+	}
+}
+
+func TestClockInvalidDelta(t *testing.T) {
+	for _, delta := range []float64{0, -0.01} {
+		if err := Try(func() { NewClock(delta) }); err == nil {
+			t.Errorf("fixed delta %v accepted", delta)
+		}
+	}
+}
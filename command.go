@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func RunCommand(name string, args []string) {
+	switch name {
+	case "validate":
+		ValidateCommand(args)
+	case "compile":
+		CompileCommand(args)
+	case "optimize":
+		OptimizeCommand(args)
+	case "convert":
+		ConvertCommand(args)
+	default:
+		fmt.Printf("unknown command %s\n", name)
+		os.Exit(2)
+	}
+}
+
+// validate <model3.json>... 存在 error 级别问题时返回非 0
+func ValidateCommand(paths []string) {
+	errCount := 0
+	for _, path := range paths {
+		problems := ValidateModel(path)
+		for _, problem := range problems {
+			fmt.Println(problem)
+			if problem.Level == LevelError {
+				errCount++
+			}
+		}
+		fmt.Printf("%s: %d problems\n", path, len(problems))
+	}
+	if errCount > 0 {
+		os.Exit(1)
+	}
+}
+
+// compile <model3.json>... 把所有动作转换为二进制文件，放在 json 旁边
+// 只支持普通目录，压缩包中的模型需要先解压
+func CompileCommand(paths []string) {
+	for _, path := range paths {
+		modelData := &ModelData{}
+		UnmarshalFile(os.DirFS(filepath.Dir(path)), filepath.Base(path), modelData)
+		count := 0
+		for _, motions := range modelData.FileReferences.Motions {
+			for _, motion := range motions {
+				file := filepath.Join(filepath.Dir(path), filepath.FromSlash(motion.File))
+				motionData := ReadMotionData(os.DirFS(filepath.Dir(path)), motion)
+				bs := EncodeMotion(ConvertMotion(motionData))
+				HandleErr(os.WriteFile(GetMotionBinPath(file), bs, 0644))
+				count++
+			}
+		}
+		fmt.Printf("%s: %d motions compiled\n", path, count)
+	}
+}
+
+// optimize [-error 0.001] [-out dir] [-n] <model3.json>... 重新拟合动作曲线
+// 指定 -out 时按原来的相对路径写到 out 目录中，否则覆盖原来的 motion3.json，-n 只输出结果不写文件
+// 需要 moc 获取参数范围与默认值，只支持普通目录
+func OptimizeCommand(args []string) {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	maxError := flags.Float64("error", 0.001, "max error relative to parameter range")
+	out := flags.String("out", "", "output directory, overwrite the source files if empty")
+	dryRun := flags.Bool("n", false, "print the result without writing files")
+	HandleErr(flags.Parse(args))
+	for _, path := range flags.Args() {
+		dir := filepath.Dir(path)
+		modelData := &ModelData{}
+		UnmarshalFile(os.DirFS(dir), filepath.Base(path), modelData)
+		ref := modelData.FileReferences
+		moc := LoadMoc(ReadFile(os.DirFS(dir), filepath.ToSlash(ref.Moc)))
+		params := GetParameters(moc.Model)
+		for _, motions := range ref.Motions {
+			for _, motion := range motions {
+				if strings.HasSuffix(motion.File, MtnExt) { // 先用 convert 转换为 motion3.json
+					fmt.Printf("%s: skip\n", motion.File)
+					continue
+				}
+				file := filepath.Join(dir, filepath.FromSlash(motion.File))
+				bs, err := os.ReadFile(file)
+				HandleErr(err)
+				motionData := &MotionData1{}
+				HandleErr(json.Unmarshal(bs, motionData))
+				motionData.Data = motion
+				optimized := ToMotion(motionData, moc)
+				res := OptimizeMotion(optimized, moc, params, *maxError)
+				newBs := MarshalMotion(optimized)
+				if len(*out) > 0 {
+					file = filepath.Join(*out, filepath.FromSlash(motion.File))
+				}
+				if !*dryRun {
+					HandleErr(os.MkdirAll(filepath.Dir(file), 0755))
+					HandleErr(os.WriteFile(file, newBs, 0644))
+				}
+				fmt.Printf("%s: size %d -> %d, %v\n", motion.File, len(bs), len(newBs), res)
+			}
+		}
+		moc.Release()
+	}
+}
+
+// convert [-map ids.json] <source.model3.json> <target.model3.json> <out>
+// 源模型可以引用 .mtn 的 Cubism 2 动作，会转换为 motion3.json
+// 源也可以是单个 .mtn 或 .exp.json 文件
+// 把源模型的所有动作重定向到目标模型，按原来的相对路径写到 out 目录中
+// ids.json 为 {"源 Id": "目标 Id"}，优先于内置的 Cubism 2 -> 3 别名
+func ConvertCommand(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	mapFile := flags.String("map", "", "id mapping json")
+	HandleErr(flags.Parse(args))
+	Assert(flags.NArg() == 3, "usage: convert [-map ids.json] <source.model3.json> <target.model3.json> <out>")
+	ids := make(map[string]string)
+	if len(*mapFile) > 0 {
+		UnmarshalFile(os.DirFS(filepath.Dir(*mapFile)), filepath.Base(*mapFile), &ids)
+	}
+	srcPath, dstPath, out := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	srcDir, dstDir := filepath.Dir(srcPath), filepath.Dir(dstPath)
+	dstData := &ModelData{}
+	UnmarshalFile(os.DirFS(dstDir), filepath.Base(dstPath), dstData)
+	dstMoc := LoadMoc(ReadFile(os.DirFS(dstDir), filepath.ToSlash(dstData.FileReferences.Moc)))
+	dstParams := GetParameters(dstMoc.Model)
+	defer dstMoc.Release()
+	if !strings.HasSuffix(srcPath, ".model3.json") { // 单个 Cubism 2 文件，没有源 moc，不映射范围
+		ConvertFile(srcPath, NewRetarget(nil, ids), dstMoc, dstParams, out)
+		return
+	}
+	srcData := &ModelData{}
+	UnmarshalFile(os.DirFS(srcDir), filepath.Base(srcPath), srcData)
+	srcMoc := LoadMoc(ReadFile(os.DirFS(srcDir), filepath.ToSlash(srcData.FileReferences.Moc)))
+	defer srcMoc.Release()
+	retarget := NewRetarget(GetParameters(srcMoc.Model), ids)
+	for _, motions := range srcData.FileReferences.Motions {
+		for _, motion := range motions {
+			motionData := ReadMotionData(os.DirFS(srcDir), motion)
+			res := retarget.RetargetMotion(ToMotion(motionData, srcMoc), dstMoc, dstParams)
+			outFile := filepath.Join(out, filepath.FromSlash(strings.TrimSuffix(motion.File, MtnExt)))
+			if strings.HasSuffix(motion.File, MtnExt) { // .mtn 转换为 motion3.json
+				outFile += ".motion3.json"
+			}
+			HandleErr(os.MkdirAll(filepath.Dir(outFile), 0755))
+			SaveMotion(outFile, res)
+			fmt.Printf("%s: %d/%d curves\n", motion.File, len(res.Curves), len(motionData.Curves))
+		}
+	}
+}
+
+// .mtn 转换为 .motion3.json，.exp.json 转换为 .exp3.json，写到 out 目录中
+func ConvertFile(path string, retarget *Retarget, moc *Moc, params []*Parameter, out string) {
+	bs, err := os.ReadFile(path)
+	HandleErr(err)
+	name := filepath.Base(path)
+	HandleErr(os.MkdirAll(out, 0755))
+	switch {
+	case strings.HasSuffix(name, MtnExt):
+		motionData, err := ParseMtn(bs)
+		HandleErr(err)
+		res := retarget.RetargetMotion(ConvertMotion(motionData), moc, params)
+		SaveMotion(filepath.Join(out, strings.TrimSuffix(name, MtnExt)+".motion3.json"), res)
+		fmt.Printf("%s: %d/%d curves\n", path, len(res.Curves), len(motionData.Curves))
+	case strings.HasSuffix(name, Exp2Ext):
+		expressionData, err := ParseExpression(bs, name)
+		HandleErr(err)
+		res := retarget.RetargetExpression(expressionData, moc, params)
+		bs, err = json.MarshalIndent(res, "", "\t")
+		HandleErr(err)
+		HandleErr(os.WriteFile(filepath.Join(out, strings.TrimSuffix(name, Exp2Ext)+".exp3.json"), bs, 0644))
+		fmt.Printf("%s: %d/%d parameters\n", path, len(res.Parameters), len(expressionData.Parameters))
+	default:
+		panic(fmt.Sprintf("unknown file %s", path))
+	}
+}
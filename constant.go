@@ -11,6 +11,19 @@ const ( // 几种 Curve 的类型
 	CurveInverseStepped = 3
 )
 
+const MotionVersion = 3 // 写出 motion3.json 时使用的版本
+
+const (
+	DefaultFixedDelta = 1.0 / 60 // Clock 的固定步长
+	MaxFrameDelta     = 0.25     // 单帧最多推进的时间
+	MaxFixedSteps     = 16       // 单帧最多推进的固定步数，超出的时间丢弃
+	MoveSpeed         = 1200     // WASD 每秒移动的距离
+)
+
+const FitCheckCount = 4 // 拟合 Bezier 时原来的每一段检查几个点
+
+const CardanoEpsilon = 0.00001 // 系数小于它时按低一次的方程求解
+
 const (
 	TargetPartOpacity = "PartOpacity"
 	TargetParameter   = "Parameter"
@@ -26,3 +39,26 @@ const (
 	DFlagVertexPositionChange
 	DFlagBlendColorChange
 )
+
+const ( // 表情参数的混合方式
+	BlendAdd       = "Add"
+	BlendMultiply  = "Multiply"
+	BlendOverwrite = "Overwrite"
+)
+
+const DefaultFadeTime = 1.0 // 表情没有指定渐变时间时使用
+
+const (
+	PreloadMotionGroup  = "Idle"           // 加载模型时预先加载的动作组
+	DefaultMotionBudget = 32 * 1024 * 1024 // 动作缓存默认内存上限，单位字节
+)
+
+type LoopMode int
+
+const ( // PlayMotion 的循环方式
+	LoopAuto    LoopMode = iota // 按动作文件中的 Meta.Loop
+	LoopOnce                    // 播放一次后停止
+	LoopForever                 // 一直循环
+)
+
+const SnapshotFile = "snapshot.json" // 退出时保存的模型状态，下次启动时恢复
@@ -8,12 +8,15 @@ package main
 #cgo CFLAGS: -I./cubism_sdk/include
 #cgo LDFLAGS: -L./cubism_sdk/lib -lLive2DCubismCore
 
+#include <stdlib.h>
 #include "Live2DCubismCore.h"
 */
 import "C" // 采用静态链接，可以打包为一个文件，性能更好
 import (
+	"context"
 	"fmt"
-	"path/filepath"
+	"io/fs"
+	"slices"
 	"unsafe"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -35,6 +38,12 @@ const (
 	MocVersion50      = C.csmMocVersion_50
 )
 
+// 参数类型
+const (
+	ParameterTypeNormal     = C.csmParameterType_Normal
+	ParameterTypeBlendShape = C.csmParameterType_BlendShape // 混合形状参数，值为形状的权重
+)
+
 type (
 	Moc0   *C.csmMoc
 	Model0 *C.csmModel
@@ -62,85 +71,76 @@ func GetMocVersion(data []byte) uint32 {
 	return uint32(res)
 }
 
+// 普通路径直接加载，.zip 在压缩包中查找 model3.json
 func LoadModel(path string) *Model {
-	dir := filepath.Dir(path)
-	// 加载入口资源
-	modelData := &ModelData{}
-	UnmarshalFile(path, modelData)
-	ref := modelData.FileReferences
-	// 加载其他关联资源
-	physicData := &PhysicData{}
-	if len(ref.Physics) > 0 { // 物理效果
-		ref.Physics = filepath.Join(dir, ref.Physics)
-		UnmarshalFile(ref.Physics, physicData)
-	}
-	poseData := &PoseData{}
-	if len(ref.Pose) > 0 { // pose数据
-		ref.Pose = filepath.Join(dir, ref.Pose)
-		UnmarshalFile(ref.Pose, poseData)
-	}
-	displayData := &DisplayData{}
-	if len(ref.DisplayInfo) > 0 { // 展示信息
-		ref.DisplayInfo = filepath.Join(dir, ref.DisplayInfo)
-		UnmarshalFile(ref.DisplayInfo, displayData)
-	}
-	expressionDatas := make([]*ExpressionData1, 0)
-	for _, item := range ref.Expressions { // 表情信息
-		item.File = filepath.Join(dir, item.File)
-		expressionData := &ExpressionData1{}
-		UnmarshalFile(item.File, expressionData)
-		expressionData.Name = item.Name
-		expressionDatas = append(expressionDatas, expressionData)
-	}
-	motionDatas := make(map[string][]*MotionData1)
-	for name, motions := range ref.Motions { // 动作信息
-		for _, motion := range motions {
-			motion.File = filepath.Join(dir, motion.File)
-			motionData := &MotionData1{}
-			UnmarshalFile(motion.File, motionData)
-			motionData.Data = motion
-			motionDatas[name] = append(motionDatas[name], motionData)
-		}
-	}
-	userData := &UserData0{}
-	if len(ref.UserData) > 0 { // 用户自定义数据，一般没啥用
-		ref.UserData = filepath.Join(dir, ref.UserData)
-		UnmarshalFile(ref.UserData, userData)
-	}
-	// 转换路径，方便后面使用
+	fsys, name := OpenModelFS(path)
+	return LoadModelFS(fsys, name)
+}
+
+// name 为 model3.json 在 fsys 中的路径，引用的资源都相对它解析
+// 可以传入 os.DirFS、embed.FS、zip.Reader 或测试用的 fstest.MapFS
+func LoadModelFS(fsys fs.FS, name string) *Model {
+	src, err := ParseModel(context.Background(), fsys, name, &Progress{})
+	HandleErr(err)
+	return BuildModel(src)
+}
+
+// 只做需要在主线程完成的部分：创建纹理与绘制对象
+func BuildModel(src *ModelSource) *Model {
+	ref := src.ModelData.FileReferences
+	imgs := make(map[string]*ebiten.Image)
 	for i, texture := range ref.Textures {
-		ref.Textures[i] = filepath.Join(dir, texture)
-	}
-	ref.Moc = filepath.Join(dir, ref.Moc)
-	// 加载 moc文件
-	moc := LoadMoc(ref.Moc)
-	// 加载 drawable资源
-	ds := GetDrawables(moc.Model, ref.Textures)
-	// 转换 motion信息
-	motions := make(map[string][]*Motion)
-	for name, datas := range motionDatas {
-		for _, data := range datas {
-			motions[name] = append(motions[name], ToMotion(data))
+		if _, ok := imgs[texture]; ok {
+			continue
 		}
+		imgs[texture] = ebiten.NewImageFromImage(src.Textures[i])
 	}
+	// 加载 drawable资源
+	moc := src.Moc
+	ds := GetDrawables(moc.Model, ref.Textures, imgs)
+	parts := GetParts(moc.Model, src.DisplayData, ds)
 	return &Model{
-		RootDir:         dir,
-		ModelData:       modelData,
-		PhysicData:      physicData,
-		PoseData:        poseData,
-		DisplayData:     displayData,
-		ExpressionDatas: expressionDatas,
-		MotionDatas:     motionDatas,
-		UserData:        userData,
+		FS:              src.FS,
+		RootDir:         src.RootDir,
+		ModelData:       src.ModelData,
+		PhysicData:      src.PhysicData,
+		PoseData:        src.PoseData,
+		DisplayData:     src.DisplayData,
+		ExpressionDatas: src.ExpressionDatas,
+		UserData:        src.UserData,
 		Moc:             moc,
+		Images:          imgs,
+		Parameters:      GetParameters(moc.Model),
 		Drawables:       ds,
-		Motions:         motions,
+		Parts:           parts,
+		Motions:         src.Motions,
 	}
 }
 
-func LoadMoc(path string) *Moc {
+// 起始地址按 align 对齐，长度向上取整到 align 的倍数（aligned_alloc 的要求）
+// core 会一直持有指向缓存区的指针，所以在 C 中申请，不受 GC 影响，用完需要 FreeBuffer
+func AlignedBuffer(size int, align int) []byte {
+	size = (size + align - 1) / align * align
+	ptr := C.aligned_alloc(C.size_t(align), C.size_t(size))
+	Assert(ptr != nil, "alloc %d bytes fail", size)
+	return unsafe.Slice((*byte)(ptr), size)
+}
+
+func FreeBuffer(buff []byte) {
+	C.free(SliceToPtr(buff))
+}
+
+func LoadMoc(data []byte) *Moc {
 	moc := &Moc{}
-	moc.MocBuff = AlignByte(ReadFile(path), AlignofMoc)
+	// csmReviveMocInPlace 要求地址按 csmAlignofMoc 对齐
+	moc.MocBuff = AlignedBuffer(len(data), AlignofMoc)
+	loaded := false
+	defer func() { // 检查失败时释放
+		if !loaded {
+			FreeBuffer(moc.MocBuff)
+		}
+	}()
+	copy(moc.MocBuff, data)
 	// 完整性检查
 	res := C.csmHasMocConsistency(SliceToPtr(moc.MocBuff), C.uint(len(moc.MocBuff)))
 	Assert(res == 1, "moc not consistency")
@@ -149,16 +149,61 @@ func LoadMoc(path string) *Moc {
 	Assert(currVersion != 0 && currVersion <= maxVersion, "core %v not support version %v", maxVersion, currVersion)
 	// 装载 moc3文件
 	moc.Moc = C.csmReviveMocInPlace(SliceToPtr(moc.MocBuff), C.uint(len(moc.MocBuff)))
+	Assert(moc.Moc != nil && IsAligned(unsafe.Pointer(moc.Moc), AlignofMoc), "moc revive fail")
+	moc.Refs = new(int)
+	InitModel(moc)
+	loaded = true
+	return moc
+}
+
+// 同一个 moc 可以初始化出多个互不影响的模型实例，moc 数据共用
+func (m *Moc) NewInstance() *Moc {
+	Assert(!m.Released, "moc already released")
+	moc := &Moc{Moc: m.Moc, MocBuff: m.MocBuff, Refs: m.Refs}
+	InitModel(moc)
+	return moc
+}
+
+// 释放实例的缓存区，最后一个共用 moc 的实例同时释放 moc 的缓存区，返回是否是最后一个
+func (m *Moc) Release() bool {
+	Assert(!m.Released, "moc already released")
+	m.Released = true
+	FreeBuffer(m.ModelBuff)
+	m.Model, m.ModelBuff = nil, nil
+	m.ParameterValues, m.ParameterMins, m.ParameterMaxs, m.ParameterRepeats = nil, nil, nil, nil
+	m.PartOpacities = nil
+	*m.Refs--
+	if *m.Refs == 0 {
+		FreeBuffer(m.MocBuff)
+	}
+	m.Moc, m.MocBuff = nil, nil
+	return *m.Refs == 0
+}
+
+func InitModel(moc *Moc) {
 	// 获取模型大小
 	size := C.csmGetSizeofModel(moc.Moc)
 	Assert(size > 0, "moc load fail")
 	// 初始化模型
-	moc.ModelBuff = AlignByte(make([]byte, size), AlignofModel)
+	moc.ModelBuff = AlignedBuffer(int(size), AlignofModel) // 要求按 csmAlignofModel 对齐
 	moc.Model = C.csmInitializeModelInPlace(moc.Moc, SliceToPtr(moc.ModelBuff), size)
-	return moc
+	if moc.Model == nil {
+		FreeBuffer(moc.ModelBuff)
+	}
+	Assert(moc.Model != nil && IsAligned(unsafe.Pointer(moc.Model), AlignofModel), "model initialize fail")
+	*moc.Refs++
+	// id 只解析一次，后续通过索引访问
+	count := int32(C.csmGetParameterCount(moc.Model))
+	moc.ParameterIdxs = ToIndexMap(GetParameterIds(moc.Model))
+	moc.PartIdxs = ToIndexMap(GetPartIds(moc.Model))
+	moc.ParameterValues = GetParameterValues(moc.Model)
+	moc.ParameterMins = PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMinimumValues(moc.Model)), count)
+	moc.ParameterMaxs = PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMaximumValues(moc.Model)), count)
+	moc.ParameterRepeats = PtrToSlice[int32](unsafe.Pointer(C.csmGetParameterRepeats(moc.Model)), count)
+	moc.PartOpacities = GetPartOpacities(moc.Model)
 }
 
-func GetDrawables(model Model0, textures []string) []*Drawable {
+func GetDrawables(model Model0, textures []string, imgs map[string]*ebiten.Image) []*Drawable {
 	// 获取有多少绘制组件
 	count := int32(C.csmGetDrawableCount(model))
 	// 获取这些组件信息
@@ -177,20 +222,7 @@ func GetDrawables(model Model0, textures []string) []*Drawable {
 	mCounts := PtrToSlice[int32](unsafe.Pointer(C.csmGetDrawableMaskCounts(model)), count) // 每个绘制的 mask 数目
 	masks := PtrToSlice2[uint32](unsafe.Pointer(C.csmGetDrawableMasks(model)), mCounts)    // 使用那些 绘制对象当做遮罩
 	// 获取 id 信息
-	ids := make([]string, 0)
-	idPtr := unsafe.Pointer(C.csmGetDrawableIds(model))
-	for i := int32(0); i < count; i++ {
-		// 每个指针占用 8 byte
-		ptr := *(**byte)(unsafe.Pointer(uintptr(idPtr) + uintptr(i*8))) // 来回转换主要是写入类型信息
-		ids = append(ids, PtrToStr(unsafe.Pointer(ptr)))
-	}
-	imgs := make(map[string]*ebiten.Image)
-	for _, texture := range textures {
-		if _, ok := imgs[texture]; ok {
-			continue
-		}
-		imgs[texture] = OpenImage(texture)
-	}
+	ids := GetDrawableIds(model)
 	res := make([]*Drawable, 0)
 	for i := int32(0); i < count; i++ {
 		res = append(res, &Drawable{
@@ -198,18 +230,108 @@ func GetDrawables(model Model0, textures []string) []*Drawable {
 			Texture: textures[tIdxs[i]],
 			Image:   imgs[textures[tIdxs[i]]],
 			Pos:     pos[i],
-			Uvs:     uvs[i],
-			Idxs:    idxs[i],
+			Uvs:     slices.Clone(uvs[i]), // 静态数据拷贝一份，不依赖 c 缓存区
+			Idxs:    slices.Clone(idxs[i]),
 			CFlag:   cflags[i],
 			DFlag:   dflags[i],
 			Opacity: opacities[i],
-			Masks:   masks[i],
+			Masks:   slices.Clone(masks[i]),
 			Order:   orders[i],
 		})
 	}
+	for _, drawable := range res {
+		for _, mask := range drawable.Masks {
+			drawable.MaskDs = append(drawable.MaskDs, res[mask])
+		}
+	}
+	return res
+}
+
+func GetDrawableIds(model Model0) []string {
+	count := int32(C.csmGetDrawableCount(model))
+	return PtrToStrs(unsafe.Pointer(C.csmGetDrawableIds(model)), count)
+}
+
+func GetDrawableTextureIndices(model Model0) []int32 {
+	count := int32(C.csmGetDrawableCount(model))
+	return PtrToSlice[int32](unsafe.Pointer(C.csmGetDrawableTextureIndices(model)), count)
+}
+
+func GetParameterIds(model Model0) []string {
+	count := int32(C.csmGetParameterCount(model))
+	return PtrToStrs(unsafe.Pointer(C.csmGetParameterIds(model)), count)
+}
+
+func GetParameters(model Model0) []*Parameter {
+	count := int32(C.csmGetParameterCount(model))
+	ids := GetParameterIds(model)
+	types := PtrToSlice[int32](unsafe.Pointer(C.csmGetParameterTypes(model)), count)
+	mins := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMinimumValues(model)), count)
+	maxs := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMaximumValues(model)), count)
+	defs := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterDefaultValues(model)), count)
+	repeats := PtrToSlice[int32](unsafe.Pointer(C.csmGetParameterRepeats(model)), count)
+	// 每个参数在编辑器中打的关键帧对应的参数值
+	kCounts := PtrToSlice[int32](unsafe.Pointer(C.csmGetParameterKeyCounts(model)), count)
+	keyValues := PtrToSlice2[float32](unsafe.Pointer(C.csmGetParameterKeyValues(model)), kCounts)
+	res := make([]*Parameter, 0)
+	for i := int32(0); i < count; i++ {
+		res = append(res, &Parameter{
+			Index:     i,
+			Id:        ids[i],
+			Type:      types[i],
+			Minimum:   mins[i],
+			Maximum:   maxs[i],
+			Default:   defs[i],
+			Repeat:    repeats[i] != 0,
+			KeyValues: slices.Clone(keyValues[i]),
+		})
+	}
+	return res
+}
+
+func GetPartIds(model Model0) []string {
+	count := int32(C.csmGetPartCount(model))
+	return PtrToStrs(unsafe.Pointer(C.csmGetPartIds(model)), count)
+}
+
+// 建立 part 的父子关系，并把 drawable 挂到所属的 part 上
+func GetParts(model Model0, displayData *DisplayData, ds []*Drawable) []*Part {
+	count := int32(C.csmGetPartCount(model))
+	ids := GetPartIds(model)
+	parents := PtrToSlice[int32](unsafe.Pointer(C.csmGetPartParentPartIndices(model)), count) // -1 表示没有父节点
+	names := make(map[string]string)
+	for _, item := range displayData.Parts { // 展示名来自 cdi3.json
+		names[item.Id] = item.Name
+	}
+	res := make([]*Part, 0)
+	for i := int32(0); i < count; i++ {
+		res = append(res, &Part{
+			Index: i,
+			Id:    ids[i],
+			Name:  names[ids[i]],
+		})
+	}
+	for i, parent := range parents {
+		if parent >= 0 {
+			res[i].Parent = res[parent]
+			res[parent].Children = append(res[parent].Children, res[i])
+		}
+	}
+	dParents := PtrToSlice[int32](unsafe.Pointer(C.csmGetDrawableParentPartIndices(model)), int32(len(ds)))
+	for i, parent := range dParents {
+		if parent >= 0 {
+			ds[i].Part = res[parent]
+			res[parent].Drawables = append(res[parent].Drawables, ds[i])
+		}
+	}
 	return res
 }
 
+func GetPartOpacities(model Model0) []float32 {
+	count := int32(C.csmGetPartCount(model))
+	return PtrToSlice[float32](unsafe.Pointer(C.csmGetPartOpacities(model)), count)
+}
+
 func GetCanvasInfo(model Model0) (*Vector2, *Vector2, float32) {
 	var cSize C.csmVector2
 	var cOrigin C.csmVector2
@@ -224,73 +346,58 @@ func GetCanvasInfo(model Model0) (*Vector2, *Vector2, float32) {
 		}, float32(cPixelsPerUnit)
 }
 
-func SetPartOpacity(model Model0, id string, value float32) {
-	ptr := unsafe.Pointer(C.csmGetPartOpacities(model))
-	idx := GetPartIdIndex(model, id) // 直接写入公共缓存区
-	*(*float32)(unsafe.Pointer(uintptr(ptr) + uintptr(idx*4))) = value
+// 通过加载时建立的索引直接读写公共缓存区，找不到 id 时返回 InvalidHandle
+func (m *Moc) GetParameterHandle(id string) ParameterHandle {
+	if idx, ok := m.ParameterIdxs[id]; ok {
+		return ParameterHandle(idx)
+	}
+	return InvalidHandle
 }
 
-func GetPartIdIndex(model Model0, id string) int32 {
-	count := int32(C.csmGetPartCount(model))
-	idPtr := unsafe.Pointer(C.csmGetPartIds(model))
-	for i := int32(0); i < count; i++ {
-		// 每个指针占用 8 byte
-		ptr := *(**byte)(unsafe.Pointer(uintptr(idPtr) + uintptr(i*8))) // 来回转换主要是写入类型信息
-		if PtrToStr(unsafe.Pointer(ptr)) == id {
-			return i
-		}
+func (m *Moc) GetPartHandle(id string) PartHandle {
+	if idx, ok := m.PartIdxs[id]; ok {
+		return PartHandle(idx)
 	}
-	panic(fmt.Sprintf("id %s not found", id))
+	return InvalidHandle
 }
 
-func GetParameterIdIndex(model Model0, id string) int32 {
-	count := int32(C.csmGetParameterCount(model))
-	idPtr := unsafe.Pointer(C.csmGetParameterIds(model))
-	for i := int32(0); i < count; i++ {
-		// 每个指针占用 8 byte
-		ptr := *(**byte)(unsafe.Pointer(uintptr(idPtr) + uintptr(i*8))) // 来回转换主要是写入类型信息
-		if PtrToStr(unsafe.Pointer(ptr)) == id {
-			return i
-		}
-	}
-	panic(fmt.Sprintf("id %s not found", id))
+func (m *Moc) GetParameterIdIndex(id string) int32 {
+	idx, ok := m.ParameterIdxs[id]
+	Assert(ok, "id %s not found", id)
+	return idx
 }
 
-// 这里的参数值是控制多个关联对象的
-func GetParameterValue(model Model0, id string) float32 {
-	idx := GetParameterIdIndex(model, id)
-	count := int32(C.csmGetParameterCount(model))
-	vals := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterValues(model)), count)
-	return vals[idx]
+func (m *Moc) GetPartIdIndex(id string) int32 {
+	idx, ok := m.PartIdxs[id]
+	Assert(ok, "id %s not found", id)
+	return idx
 }
 
-// 分别获取参数可取的最大/最小/默认值
+// 这里的参数值是控制多个关联对象的
+func (m *Moc) GetParameterValue(handle ParameterHandle) float32 {
+	return m.ParameterValues[handle]
+}
 
-func GetParameterMaximumValues(model Model0, id string) float32 {
-	idx := GetParameterIdIndex(model, id)
-	count := int32(C.csmGetParameterCount(model))
-	vals := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMaximumValues(model)), count)
-	return vals[idx]
+// 普通参数限制在最大最小值之间，循环参数（例如旋转角度）超出范围后从另一端绕回
+func (m *Moc) SetParameterValue(handle ParameterHandle, value float32) {
+	if m.ParameterRepeats[handle] != 0 {
+		m.ParameterValues[handle] = RepeatValue(value, m.ParameterMins[handle], m.ParameterMaxs[handle])
+	} else {
+		m.ParameterValues[handle] = Clamp(value, m.ParameterMins[handle], m.ParameterMaxs[handle])
+	}
 }
 
-func GetParameterMinimumValues(model Model0, id string) float32 {
-	idx := GetParameterIdIndex(model, id)
-	count := int32(C.csmGetParameterCount(model))
-	vals := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterMinimumValues(model)), count)
-	return vals[idx]
+func (m *Moc) GetPartOpacity(handle PartHandle) float32 {
+	return m.PartOpacities[handle]
 }
 
-func GetParameterDefaultValues(model Model0, id string) float32 {
-	idx := GetParameterIdIndex(model, id)
-	count := int32(C.csmGetParameterCount(model))
-	vals := PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterDefaultValues(model)), count)
-	return vals[idx]
+func (m *Moc) SetPartOpacity(handle PartHandle, value float32) {
+	m.PartOpacities[handle] = value
 }
 
-func SetParameterValue(model Model0, id string, value float32) {
-	idx := GetParameterIdIndex(model, id)
-	ptr := unsafe.Pointer(C.csmGetParameterValues(model))
-	*(*float32)(unsafe.Pointer(uintptr(ptr) + uintptr(idx*4))) = value
+func GetParameterValues(model Model0) []float32 {
+	count := int32(C.csmGetParameterCount(model))
+	return PtrToSlice[float32](unsafe.Pointer(C.csmGetParameterValues(model)), count)
 }
 
 func Update(model Model0) {
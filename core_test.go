@@ -0,0 +1,85 @@
+//go:build display
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"unsafe"
+)
+
+// 对比每帧按 id 查找参数（原来的方式，每次都解码所有 id）与加载时绑定的句柄
+// go test -tags display -bench Frame -run ^$
+func BenchmarkFrameById(b *testing.B) {
+	model := LoadTestModel(b)
+	defer model.Release()
+	motion := model.GetMotion(PreloadMotionGroup, 0)
+	moc := model.Moc
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timer := float64(i%60) / 60 * motion.Data.Meta.Duration
+		for _, curve := range motion.Curves {
+			if curve.Data.Target != TargetParameter {
+				continue
+			}
+			value, ok := GetCurveValue(curve, timer, nil)
+			idx := slices.Index(GetParameterIds(moc.Model), curve.Data.Id)
+			if ok && idx >= 0 {
+				moc.SetParameterValue(ParameterHandle(idx), float32(value))
+			}
+		}
+		Update(moc.Model)
+	}
+}
+
+func BenchmarkFrameByHandle(b *testing.B) {
+	model := LoadTestModel(b)
+	defer model.Release()
+	motion := model.GetMotion(PreloadMotionGroup, 0)
+	moc := model.Moc
+	cursors := make([]int, len(motion.Curves))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timer := float64(i%60) / 60 * motion.Data.Meta.Duration
+		for j, curve := range motion.Curves {
+			if curve.Data.Target != TargetParameter || curve.Handle == InvalidHandle {
+				continue
+			}
+			if value, ok := GetCurveValue(curve, timer, &cursors[j]); ok {
+				moc.SetParameterValue(ParameterHandle(curve.Handle), float32(value))
+			}
+		}
+		Update(moc.Model)
+	}
+}
+
+// csmReviveMocInPlace 与 csmInitializeModelInPlace 要求的对齐，新实例同样满足
+func TestMocAligned(t *testing.T) {
+	model := LoadTestModel(t)
+	defer model.Release()
+	instance := model.NewInstance()
+	defer instance.Release()
+	for _, moc := range []*Moc{model.Moc, instance.Moc} {
+		if !IsAligned(unsafe.Pointer(moc.Moc), AlignofMoc) || !IsAligned(unsafe.Pointer(&moc.MocBuff[0]), AlignofMoc) {
+			t.Errorf("moc %p buff %p not aligned to %d", moc.Moc, &moc.MocBuff[0], AlignofMoc)
+		}
+		if !IsAligned(unsafe.Pointer(moc.Model), AlignofModel) || !IsAligned(unsafe.Pointer(&moc.ModelBuff[0]), AlignofModel) {
+			t.Errorf("model %p buff %p not aligned to %d", moc.Model, &moc.ModelBuff[0], AlignofModel)
+		}
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, align := range []int{AlignofModel, AlignofMoc} {
+		for _, size := range []int{1, 3, 15, 16, 17, 63, 64, 65, 100, 1000, 4096, 100000} {
+			buff := AlignedBuffer(size, align)
+			if !IsAligned(unsafe.Pointer(&buff[0]), align) {
+				t.Errorf("size %d align %d: address %p", size, align, &buff[0])
+			}
+			if len(buff) < size || len(buff)%align != 0 || cap(buff) != len(buff) {
+				t.Errorf("size %d align %d: len %d cap %d", size, align, len(buff), cap(buff))
+			}
+			FreeBuffer(buff)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Cubism 2 的动作与表情，转换为现有的结构后按 Id 播放，Id 不同时配合 Retarget 使用
+const (
+	MtnExt  = ".mtn"
+	Exp2Ext = ".exp.json" // Cubism 3 为 .exp3.json
+)
+
+// .mtn 是文本格式，# 开头为注释，$ 开头为设置，其余每行为一个参数每帧的值：
+// $fps=30
+// $fadein=1000
+// PARAM_ANGLE_X=0,0.5,1
+// VISIBLE:PARTS_01_FACE=1,1,1
+func ParseMtn(bs []byte) (*MotionData1, error) {
+	fps := 30.0
+	fadeIns, fadeOuts := make(map[string]float64), make(map[string]float64)
+	data := &MotionData1{Version: MotionVersion, Meta: &MetaData1{AreBeziersRestricted: true}} // 没有循环标记，默认不循环
+	frames := 0
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	scanner.Buffer(nil, 16*1024*1024) // 一行包含所有帧，可能很长
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing '='", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(key, "$") {
+			num, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			name, id, _ := strings.Cut(key[1:], ":") // $fadein:PARAM_ANGLE_X=500 单个参数的渐变
+			switch {
+			case name == "fps" && num > 0:
+				fps = num
+			case name == "fadein" && len(id) == 0: // 对应 motion3.json Meta 中的渐变时间
+				fadeIn := num / 1000
+				data.Meta.FadeInTime = &fadeIn
+			case name == "fadeout" && len(id) == 0:
+				fadeOut := num / 1000
+				data.Meta.FadeOutTime = &fadeOut
+			case name == "fadein":
+				fadeIns[id] = num / 1000
+			case name == "fadeout":
+				fadeOuts[id] = num / 1000
+			}
+			continue
+		}
+		target, id := TargetParameter, key
+		if prefix, name, ok := strings.Cut(key, ":"); ok {
+			if prefix != "VISIBLE" { // LAYOUT 等没有对应的曲线
+				continue
+			}
+			target, id = TargetPartOpacity, name
+		}
+		values := make([]float64, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) == 0 {
+				continue
+			}
+			num, err := strconv.ParseFloat(item, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			values = append(values, num)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		frames = max(frames, len(values))
+		data.Curves = append(data.Curves, &CurveData{Target: target, Id: id, Segments: values}) // 先暂存每帧的值
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	data.Meta.Fps = fps
+	data.Meta.Duration = float64(max(frames-1, 1)) / fps
+	for _, curve := range data.Curves { // 每帧一个线性关键帧
+		values := curve.Segments
+		if len(values) == 1 { // 只有一帧时保持到结尾
+			values = append(values, values[0])
+		}
+		segments := []float64{0, values[0]}
+		for i := 1; i < len(values); i++ {
+			time := float64(i) / fps
+			if len(curve.Segments) == 1 {
+				time = data.Meta.Duration
+			}
+			segments = append(segments, CurveLinear, time, values[i])
+		}
+		curve.Segments = segments
+		if fadeIn, ok := fadeIns[curve.Id]; ok {
+			curve.FadeInTime = &fadeIn
+		}
+		if fadeOut, ok := fadeOuts[curve.Id]; ok {
+			curve.FadeOutTime = &fadeOut
+		}
+	}
+	// Meta 中的数目与写出时一样重新统计
+	data.Meta = ToMotionData(ConvertMotion(data)).Meta
+	return data, nil
+}
+
+func ToExpression(data *ExpressionData2) *ExpressionData1 {
+	res := &ExpressionData1{Type: "Live2D Expression", Parameters: make([]*ParameterData1, 0)}
+	if data.FadeIn != nil {
+		fadeIn := *data.FadeIn / 1000
+		res.FadeInTime = &fadeIn
+	}
+	if data.FadeOut != nil {
+		fadeOut := *data.FadeOut / 1000
+		res.FadeOutTime = &fadeOut
+	}
+	for _, item := range data.Params {
+		param := &ParameterData1{Id: item.Id}
+		switch item.Calc {
+		case "mult": // 相对默认值的倍数
+			def := ElemOrDef(item.Def, 1)
+			param.Blend, param.Value = BlendMultiply, item.Val
+			if def != 0 {
+				param.Value = item.Val / def
+			}
+		case "set":
+			param.Blend, param.Value = BlendOverwrite, item.Val
+		default: // 相对默认值的偏移
+			param.Blend, param.Value = BlendAdd, item.Val-ElemOrDef(item.Def, 0)
+		}
+		res.Parameters = append(res.Parameters, param)
+	}
+	return res
+}
+
+// 按扩展名区分 Cubism 2 与 Cubism 3 的表情
+func ParseExpression(bs []byte, file string) (*ExpressionData1, error) {
+	if strings.HasSuffix(file, Exp2Ext) {
+		data := &ExpressionData2{}
+		if err := json.Unmarshal(bs, data); err != nil {
+			return nil, err
+		}
+		return ToExpression(data), nil
+	}
+	res := &ExpressionData1{}
+	if err := json.Unmarshal(bs, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func LoadExpression(fsys fs.FS, file string) *ExpressionData1 {
+	res, err := ParseExpression(ReadFile(fsys, file), file)
+	HandleErr(err)
+	return res
+}
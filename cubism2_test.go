@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Float(value float64) *float64 {
+	return &value
+}
+
+func TestParseMtn(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		fps      float64
+		duration float64
+		fadeIn   *float64
+		fadeOut  *float64
+		curves   []*CurveData
+	}{
+		{"default fps", "PARAM_A=0,1,0.5", 30, 2.0 / 30, nil, nil, []*CurveData{
+			{Target: TargetParameter, Id: "PARAM_A", Segments: []float64{0, 0, CurveLinear, 1.0 / 30, 1, CurveLinear, 2.0 / 30, 0.5}},
+		}},
+		{"fps and fades", "# comment\n$fps=10\n$fadein=500\n$fadeout=1000\n$fadein:PARAM_A=200\n$fadeout:PARAM_B=300\nPARAM_A=0,1,2\nPARAM_B=5,6,7",
+			10, 0.2, Float(0.5), Float(1), []*CurveData{
+				{Target: TargetParameter, Id: "PARAM_A", FadeInTime: Float(0.2), Segments: []float64{0, 0, CurveLinear, 0.1, 1, CurveLinear, 0.2, 2}},
+				{Target: TargetParameter, Id: "PARAM_B", FadeOutTime: Float(0.3), Segments: []float64{0, 5, CurveLinear, 0.1, 6, CurveLinear, 0.2, 7}},
+			}},
+		{"prefixes", "$fps=10\nVISIBLE:PARTS_01=1,0\nLAYOUT:CENTER_X=0,0\nPARAM_A=1,1", 10, 0.1, nil, nil, []*CurveData{
+			{Target: TargetPartOpacity, Id: "PARTS_01", Segments: []float64{0, 1, CurveLinear, 0.1, 0}},
+			{Target: TargetParameter, Id: "PARAM_A", Segments: []float64{0, 1, CurveLinear, 0.1, 1}},
+		}},
+		{"single frame", "$fps=10\nPARAM_A=0,1,2\nPARAM_B=5\n\nPARAM_C=", 10, 0.2, nil, nil, []*CurveData{
+			{Target: TargetParameter, Id: "PARAM_A", Segments: []float64{0, 0, CurveLinear, 0.1, 1, CurveLinear, 0.2, 2}},
+			{Target: TargetParameter, Id: "PARAM_B", Segments: []float64{0, 5, CurveLinear, 0.2, 5}},
+		}},
+		{"only single frames", "$fps=10\nPARAM_A=3", 10, 0.1, nil, nil, []*CurveData{
+			{Target: TargetParameter, Id: "PARAM_A", Segments: []float64{0, 3, CurveLinear, 0.1, 3}},
+		}},
+	}
+	for _, test := range tests {
+		data, err := ParseMtn([]byte(test.input))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		meta := data.Meta
+		if meta.Loop || !meta.AreBeziersRestricted || meta.Fps != test.fps || meta.Duration != test.duration {
+			t.Errorf("%s: meta %+v", test.name, meta)
+		}
+		if !reflect.DeepEqual(meta.FadeInTime, test.fadeIn) || !reflect.DeepEqual(meta.FadeOutTime, test.fadeOut) {
+			t.Errorf("%s: fade %v %v", test.name, meta.FadeInTime, meta.FadeOutTime)
+		}
+		if !reflect.DeepEqual(data.Curves, test.curves) {
+			for _, curve := range data.Curves {
+				t.Logf("%+v", curve)
+			}
+			t.Errorf("%s: curves changed", test.name)
+		}
+		if meta.CurveCount != len(test.curves) {
+			t.Errorf("%s: curve count %d", test.name, meta.CurveCount)
+		}
+	}
+}
+
+func TestParseMtnError(t *testing.T) {
+	for _, input := range []string{"PARAM_A", "PARAM_A=0,x", "$fps=x"} {
+		if _, err := ParseMtn([]byte(input)); err == nil {
+			t.Errorf("%q should fail", input)
+		}
+	}
+}
+
+// add 与 mult 相对 def，默认分别为 0 与 1，set 直接覆盖
+func TestToExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		param *ParameterData2
+		want  *ParameterData1
+	}{
+		{"add", &ParameterData2{Id: "A", Val: 1}, &ParameterData1{Id: "A", Value: 1, Blend: BlendAdd}},
+		{"add def", &ParameterData2{Id: "A", Val: 1, Calc: "add", Def: Float(0.25)}, &ParameterData1{Id: "A", Value: 0.75, Blend: BlendAdd}},
+		{"mult", &ParameterData2{Id: "A", Val: 2, Calc: "mult"}, &ParameterData1{Id: "A", Value: 2, Blend: BlendMultiply}},
+		{"mult def", &ParameterData2{Id: "A", Val: 2, Calc: "mult", Def: Float(0.5)}, &ParameterData1{Id: "A", Value: 4, Blend: BlendMultiply}},
+		{"mult zero def", &ParameterData2{Id: "A", Val: 2, Calc: "mult", Def: Float(0)}, &ParameterData1{Id: "A", Value: 2, Blend: BlendMultiply}},
+		{"set", &ParameterData2{Id: "A", Val: 0.5, Calc: "set"}, &ParameterData1{Id: "A", Value: 0.5, Blend: BlendOverwrite}},
+		{"set def", &ParameterData2{Id: "A", Val: 0.5, Calc: "set", Def: Float(1)}, &ParameterData1{Id: "A", Value: 0.5, Blend: BlendOverwrite}},
+	}
+	for _, test := range tests {
+		res := ToExpression(&ExpressionData2{Params: []*ParameterData2{test.param}})
+		if len(res.Parameters) != 1 || !reflect.DeepEqual(res.Parameters[0], test.want) {
+			t.Errorf("%s: got %+v want %+v", test.name, res.Parameters[0], test.want)
+		}
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		input   string
+		want    *ExpressionData1
+		wantErr bool
+	}{
+		{"cubism 2", "f01.exp.json", `{"type":"Live2D Expression","fade_in":500,"fade_out":250,"params":[{"id":"PARAM_EYE_L_OPEN","val":0.5,"calc":"mult"}]}`,
+			&ExpressionData1{Type: "Live2D Expression", FadeInTime: Float(0.5), FadeOutTime: Float(0.25),
+				Parameters: []*ParameterData1{{Id: "PARAM_EYE_L_OPEN", Value: 0.5, Blend: BlendMultiply}}}, false},
+		{"cubism 2 no fade", "f02.exp.json", `{"params":[{"id":"PARAM_A","val":1}]}`,
+			&ExpressionData1{Type: "Live2D Expression", Parameters: []*ParameterData1{{Id: "PARAM_A", Value: 1, Blend: BlendAdd}}}, false},
+		{"cubism 3", "f01.exp3.json", `{"Type":"Live2D Expression","FadeInTime":0.5,"Parameters":[{"Id":"ParamA","Value":1,"Blend":"Add"}]}`,
+			&ExpressionData1{Type: "Live2D Expression", FadeInTime: Float(0.5), Parameters: []*ParameterData1{{Id: "ParamA", Value: 1, Blend: BlendAdd}}}, false},
+		{"invalid", "f01.exp.json", `{"params":`, nil, true},
+	}
+	for _, test := range tests {
+		res, err := ParseExpression([]byte(test.input), test.file)
+		if (err != nil) != test.wantErr || !reflect.DeepEqual(res, test.want) {
+			t.Errorf("%s: got %+v %v", test.name, res, err)
+		}
+	}
+}
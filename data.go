@@ -39,11 +39,11 @@ type ExpressionData0 struct {
 }
 
 type MotionData0 struct {
-	File        string  `json:"File"`
-	FadeInTime  float64 `json:"FadeInTime"`
-	FadeOutTime float64 `json:"FadeOutTime"`
-	Sound       string  `json:"Sound"`
-	MotionSync  string  `json:"MotionSync"`
+	File        string   `json:"File"`
+	FadeInTime  *float64 `json:"FadeInTime,omitempty"` // 没有时使用动作 Meta 中的
+	FadeOutTime *float64 `json:"FadeOutTime,omitempty"`
+	Sound       string   `json:"Sound"`
+	MotionSync  string   `json:"MotionSync"`
 }
 
 type PhysicData struct {
@@ -73,12 +73,12 @@ type SourceData struct {
 }
 
 type OutputData struct {
-	Destination *Vector2 `json:"Destination"`
-	VertexIndex int      `json:"VertexIndex"`
-	Scale       float64  `json:"Scale"`
-	Weight      int      `json:"Weight"`
-	Type        string   `json:"Type"`
-	Reflect     bool     `json:"Reflect"`
+	Destination *SourceData `json:"Destination"`
+	VertexIndex int         `json:"VertexIndex"`
+	Scale       float64     `json:"Scale"`
+	Weight      int         `json:"Weight"`
+	Type        string      `json:"Type"`
+	Reflect     bool        `json:"Reflect"`
 }
 
 type VerticesData struct {
@@ -150,9 +150,26 @@ type ParameterData0 struct {
 }
 
 type ExpressionData1 struct {
-	Name       string            `json:"-"`
-	Type       string            `json:"Type"`
-	Parameters []*ParameterData1 `json:"Parameters"`
+	Name        string            `json:"-"`
+	Type        string            `json:"Type"`
+	FadeInTime  *float64          `json:"FadeInTime,omitempty"`
+	FadeOutTime *float64          `json:"FadeOutTime,omitempty"`
+	Parameters  []*ParameterData1 `json:"Parameters"`
+}
+
+// Cubism 2 的 .exp.json，时间单位为毫秒
+type ExpressionData2 struct {
+	Type    string            `json:"type"`
+	FadeIn  *float64          `json:"fade_in"`
+	FadeOut *float64          `json:"fade_out"`
+	Params  []*ParameterData2 `json:"params"`
+}
+
+type ParameterData2 struct {
+	Id   string   `json:"id"`
+	Val  float64  `json:"val"`
+	Calc string   `json:"calc"` // add mult set，默认为 add
+	Def  *float64 `json:"def"`  // add 默认 0，mult 默认 1
 }
 
 type ParameterData1 struct {
@@ -162,30 +179,38 @@ type ParameterData1 struct {
 }
 
 type MotionData1 struct {
-	Data    *MotionData0 `json:"-"`
-	Version int          `json:"Version"`
-	Meta    *MetaData1   `json:"Meta"`
-	Curves  []*CurveData `json:"Curves"`
+	Data     *MotionData0 `json:"-"`
+	Version  int          `json:"Version"`
+	Meta     *MetaData1   `json:"Meta"`
+	Curves   []*CurveData `json:"Curves"`
+	UserData []*UserData3 `json:"UserData,omitempty"`
 }
 
 type CurveData struct {
 	Target      string    `json:"Target"`
 	Id          string    `json:"Id"`
-	FadeInTime  *float64  `json:"FadeInTime"`
-	FadeOutTime *float64  `json:"FadeOutTime"`
+	FadeInTime  *float64  `json:"FadeInTime,omitempty"`
+	FadeOutTime *float64  `json:"FadeOutTime,omitempty"`
 	Segments    []float64 `json:"Segments"`
 }
 
+type UserData3 struct {
+	Time  float64 `json:"Time"`
+	Value string  `json:"Value"`
+}
+
 type MetaData1 struct {
-	Duration             float64 `json:"Duration"`
-	Fps                  float64 `json:"Fps"`
-	Loop                 bool    `json:"Loop"`
-	AreBeziersRestricted bool    `json:"AreBeziersRestricted"`
-	CurveCount           int     `json:"CurveCount"`
-	TotalSegmentCount    int     `json:"TotalSegmentCount"`
-	TotalPointCount      int     `json:"TotalPointCount"`
-	UserDataCount        int     `json:"UserDataCount"`
-	TotalUserDataSize    int     `json:"TotalUserDataSize"`
+	Duration             float64  `json:"Duration"`
+	Fps                  float64  `json:"Fps"`
+	Loop                 bool     `json:"Loop"`
+	AreBeziersRestricted bool     `json:"AreBeziersRestricted"`
+	FadeInTime           *float64 `json:"FadeInTime,omitempty"`
+	FadeOutTime          *float64 `json:"FadeOutTime,omitempty"`
+	CurveCount           int      `json:"CurveCount"`
+	TotalSegmentCount    int      `json:"TotalSegmentCount"`
+	TotalPointCount      int      `json:"TotalPointCount"`
+	UserDataCount        int      `json:"UserDataCount"`
+	TotalUserDataSize    int      `json:"TotalUserDataSize"`
 }
 
 type UserData0 struct {
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 运行时重新加载修改过的动作、表情、物理、pose 与纹理，不重置当前姿势
+// 同一个 model3.json 的所有实例共用数据，一起替换
+type HotReloader struct {
+	Scene   *Scene
+	Path    string
+	Watcher *Watcher
+	Errs    map[string]error // 每个文件最近一次重新加载的错误，显示在界面上而不是直接崩溃，重新加载成功后清除
+}
+
+func NewHotReloader(scene *Scene, path string) *HotReloader {
+	model := scene.Models[path]
+	ref := model.ModelData.FileReferences
+	files := make([]string, 0)
+	for _, motions := range ref.Motions {
+		for _, motion := range motions {
+			files = append(files, motion.File, GetMotionBinPath(motion.File)) // 重新 compile 也算修改
+		}
+	}
+	for _, item := range ref.Expressions {
+		files = append(files, item.File)
+	}
+	for _, file := range []string{ref.Physics, ref.Pose} {
+		if len(file) > 0 {
+			files = append(files, file)
+		}
+	}
+	files = append(files, ref.Textures...)
+	return &HotReloader{Scene: scene, Path: path, Watcher: NewWatcher(model.FS, files, 1), Errs: make(map[string]error)}
+}
+
+func (r *HotReloader) Update(delta float64) {
+	for _, file := range r.Watcher.Update(delta) {
+		if err := Try(func() { r.Reload(file) }); err != nil {
+			r.Errs[file] = fmt.Errorf("reload %s: %v", file, err)
+		} else {
+			delete(r.Errs, file)
+			fmt.Printf("reload %s\n", file)
+		}
+	}
+}
+
+// 按文件名排序，界面上的顺序保持不变
+func (r *HotReloader) GetErrs() []error {
+	files := make([]string, 0)
+	for file := range r.Errs {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	res := make([]error, 0)
+	for _, file := range files {
+		res = append(res, r.Errs[file])
+	}
+	return res
+}
+
+func (r *HotReloader) GetManagers() []*MotionManager {
+	res := make([]*MotionManager, 0)
+	for _, instance := range r.Scene.Instances {
+		if instance.Path == r.Path {
+			res = append(res, instance.MotionManager)
+		}
+	}
+	return res
+}
+
+// 先完整解析再替换，解析失败时保持原来的数据
+func (r *HotReloader) Reload(file string) {
+	model := r.Scene.Models[r.Path]
+	ref := model.ModelData.FileReferences
+	found := false
+	for _, motions := range ref.Motions { // 同一个文件可能被多个动作组引用，缓存中各有一份
+		for _, motion := range motions {
+			if motion.File == file || GetMotionBinPath(motion.File) == file {
+				r.ReloadMotion(motion)
+				found = true
+			}
+		}
+	}
+	if found {
+		return
+	}
+	for i, item := range ref.Expressions {
+		if item.File == file {
+			expressionData := LoadExpression(model.FS, file)
+			expressionData.Name = item.Name
+			old := model.ExpressionDatas[i]
+			model.ExpressionDatas[i] = expressionData // 切片是共用的
+			for _, manager := range r.GetManagers() {
+				if manager.Expression == old {
+					manager.Expression = expressionData
+				}
+			}
+			return
+		}
+	}
+	switch file {
+	case ref.Physics:
+		physicData := &PhysicData{}
+		UnmarshalFile(model.FS, file, physicData)
+		for _, manager := range r.GetManagers() {
+			manager.Model.PhysicData = physicData
+		}
+		return
+	case ref.Pose:
+		poseData := &PoseData{}
+		UnmarshalFile(model.FS, file, poseData)
+		for _, manager := range r.GetManagers() {
+			manager.Model.PoseData = poseData
+		}
+		return
+	}
+	if strings.HasSuffix(file, ".png") {
+		r.ReloadTexture(file)
+		return
+	}
+	panic(fmt.Sprintf("unknown file %s", file))
+}
+
+func (r *HotReloader) ReloadMotion(ref *MotionData0) {
+	model := r.Scene.Models[r.Path]
+	motion := LoadMotion(model.FS, ref, model.Moc)
+	// 缓存是共用的，替换一次所有实例都能看到
+	model.Motions.Put(ref, motion)
+	for _, manager := range r.GetManagers() {
+		if manager.Motion != nil && manager.Motion.Data.Data == ref { // 正在播放的直接换成新的，时间保持不变
+			start, end := manager.RangeStart, manager.RangeEnd
+			if start == 0 && end == manager.Motion.Data.Meta.Duration { // 原来播放整个动作，区间跟着新的长度变化
+				end = 0
+			}
+			manager.Motion = motion
+			manager.SetRange(start, end)
+		}
+	}
+}
+
+func (r *HotReloader) ReloadTexture(file string) {
+	model := r.Scene.Models[r.Path]
+	img := OpenImage(model.FS, file)
+	old := model.Images[file]
+	model.Images[file] = img
+	for _, manager := range r.GetManagers() {
+		for _, drawable := range manager.Model.Drawables {
+			if drawable.Texture == file {
+				drawable.Image = img
+			}
+		}
+		manager.LastTransform = Transform{} // 纹理大小可能变了，重新计算所有顶点的 uv
+	}
+	old.Deallocate()
+}
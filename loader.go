@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"runtime"
+	"sync"
+)
+
+// 后台解析好的模型数据，不包含任何 ebiten 资源，可以在任意协程中生成
+type ModelSource struct {
+	FS              fs.FS
+	RootDir         string
+	ModelData       *ModelData
+	PhysicData      *PhysicData
+	PoseData        *PoseData
+	DisplayData     *DisplayData
+	ExpressionDatas []*ExpressionData1
+	UserData        *UserData0
+	MocData         []byte
+	Moc             *Moc
+	Textures        []image.Image // 与 FileReferences.Textures 一一对应
+	Motions         *MotionCache
+}
+
+// 加载进度，多个协程同时写入
+type Progress struct {
+	Lock  sync.Mutex
+	Done  int   // 已完成的文件数
+	Total int   // 总文件数，解析完 model3.json 后才确定
+	Bytes int64 // 已读取的字节数
+}
+
+func (p *Progress) Add(done int, total int, bytes int) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	p.Done += done
+	p.Total += total
+	p.Bytes += int64(bytes)
+}
+
+func (p *Progress) Get() (int, int, int64) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	return p.Done, p.Total, p.Bytes
+}
+
+func (p *Progress) ReadFile(fsys fs.FS, file string) ([]byte, error) {
+	bs, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	p.Add(1, 0, len(bs))
+	return bs, nil
+}
+
+func (p *Progress) UnmarshalFile(fsys fs.FS, file string, dst any) error {
+	bs, err := p.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(bs, dst); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// 并发解析所有 json 与 png，路径的转换方式与之前同步加载一致
+func ParseModel(ctx context.Context, fsys fs.FS, name string, progress *Progress) (*ModelSource, error) {
+	dir := path.Dir(name)
+	src := &ModelSource{
+		FS:          fsys,
+		RootDir:     dir,
+		ModelData:   &ModelData{},
+		PhysicData:  &PhysicData{},
+		PoseData:    &PoseData{},
+		DisplayData: &DisplayData{},
+		UserData:    &UserData0{},
+		Motions:     NewMotionCache(fsys, DefaultMotionBudget),
+	}
+	// 加载入口资源
+	progress.Add(0, 1, 0)
+	if err := progress.UnmarshalFile(fsys, name, src.ModelData); err != nil {
+		return nil, err
+	}
+	ref := src.ModelData.FileReferences
+	if ref == nil {
+		return nil, fmt.Errorf("%s: missing FileReferences", name)
+	}
+	tasks := make([]func() error, 0)
+	addJson := func(file *string, dst any) {
+		*file = path.Join(dir, *file)
+		temp := *file
+		tasks = append(tasks, func() error {
+			return progress.UnmarshalFile(fsys, temp, dst)
+		})
+	}
+	// 加载其他关联资源
+	if len(ref.Physics) > 0 {
+		addJson(&ref.Physics, src.PhysicData)
+	}
+	if len(ref.Pose) > 0 {
+		addJson(&ref.Pose, src.PoseData)
+	}
+	if len(ref.DisplayInfo) > 0 {
+		addJson(&ref.DisplayInfo, src.DisplayData)
+	}
+	if len(ref.UserData) > 0 {
+		addJson(&ref.UserData, src.UserData)
+	}
+	for _, item := range ref.Expressions {
+		expressionData := &ExpressionData1{Name: item.Name}
+		src.ExpressionDatas = append(src.ExpressionDatas, expressionData)
+		item.File = path.Join(dir, item.File)
+		temp := item.File
+		tasks = append(tasks, func() error { // 可能是 Cubism 2 的格式
+			bs, err := progress.ReadFile(fsys, temp)
+			if err != nil {
+				return err
+			}
+			res, err := ParseExpression(bs, temp)
+			if err != nil {
+				return fmt.Errorf("%s: %w", temp, err)
+			}
+			name := expressionData.Name
+			*expressionData = *res
+			expressionData.Name = name
+			return nil
+		})
+	}
+	for _, motions := range ref.Motions { // 动作在播放时才加载
+		for _, motion := range motions {
+			motion.File = path.Join(dir, motion.File)
+		}
+	}
+	ref.Moc = path.Join(dir, ref.Moc)
+	tasks = append(tasks, func() (err error) {
+		src.MocData, err = progress.ReadFile(fsys, ref.Moc)
+		return err
+	})
+	src.Textures = make([]image.Image, len(ref.Textures))
+	for i, texture := range ref.Textures {
+		ref.Textures[i] = path.Join(dir, texture)
+		idx, temp := i, ref.Textures[i]
+		tasks = append(tasks, func() error {
+			bs, err := progress.ReadFile(fsys, temp)
+			if err != nil {
+				return err
+			}
+			src.Textures[idx], _, err = image.Decode(bytes.NewReader(bs))
+			if err != nil {
+				return fmt.Errorf("%s: %w", temp, err)
+			}
+			return nil
+		})
+	}
+	preloads := ref.Motions[PreloadMotionGroup]
+	progress.Add(0, len(tasks)+len(preloads), 0)
+	if err := RunTasks(ctx, tasks); err != nil {
+		return nil, err
+	}
+	// moc 的校验与曲线绑定依赖 core，放在所有文件读完之后
+	err := Try(func() {
+		src.Moc = LoadMoc(src.MocData)
+		for _, motion := range preloads {
+			if ctx.Err() != nil {
+				return
+			}
+			src.Motions.Get(motion, src.Moc)
+			progress.Add(1, 0, 0)
+		}
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// 最多同时运行 cpu 个数的任务，返回第一个错误，出错或取消后不再启动新的任务
+func RunTasks(ctx context.Context, tasks []func() error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, runtime.NumCPU())
+	wg := sync.WaitGroup{}
+	once := sync.Once{}
+	var res error
+	for _, task := range tasks {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func(task func() error) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if ctx.Err() != nil {
+					return
+				}
+				if err := task(); err != nil {
+					once.Do(func() {
+						res = err
+						cancel()
+					})
+				}
+			}(task)
+		}
+	}
+	wg.Wait()
+	if res != nil {
+		return res
+	}
+	return ctx.Err()
+}
+
+// 后台加载中的模型，需要在主线程中不断 Poll 直到完成
+type ModelLoader struct {
+	Progress *Progress
+	Done     chan struct{}
+	Source   *ModelSource
+	Err      error
+}
+
+func LoadModelAsync(ctx context.Context, fsys fs.FS, name string) *ModelLoader {
+	loader := &ModelLoader{Progress: &Progress{}, Done: make(chan struct{})}
+	go func() {
+		defer close(loader.Done)
+		loader.Source, loader.Err = ParseModel(ctx, fsys, name, loader.Progress)
+	}()
+	return loader
+}
+
+// 未完成时返回 nil, nil，完成后在当前（主）线程创建纹理
+func (l *ModelLoader) Poll() (*Model, error) {
+	select {
+	case <-l.Done:
+	default:
+		return nil, nil
+	}
+	if l.Err != nil {
+		return nil, l.Err
+	}
+	var model *Model
+	err := Try(func() {
+		model = BuildModel(l.Source)
+	})
+	return model, err
+}
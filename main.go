@@ -5,41 +5,58 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
-var (
-	Size       *Vector2
-	Origin     *Vector2
-	Scale      float32
-	IsAzurLane bool // 大部分顶点都是 -0.5～0.5 的小数，不过 碧蓝航线 需要特殊处理
-)
-
-// 空格切换动画 鼠标拖动位置
+// 空格切换动画 P 暂停 R 倒放 左右方向键拖动进度 V 开始/结束录制 E 切换表情 Tab 切换操作的模型 鼠标拖动位置 右键打印点击的部件
+// WASD 移动模型 -/= 时间缩放 T 切换确定模式
+// 带参数时执行命令，例如 go run . validate res/haru/haru.model3.json
 
 func main() {
 	fmt.Println(GetVersion())
-	model := LoadModel("res/kewei/kewei_4.model3.json")
-	Size, Origin, _ = GetCanvasInfo(model.Moc.Model)
-	Scale = min(1440/Size.X, 810/Size.Y) // 宽高限制在 0~1280 0~720
-	Size.X, Size.Y, Origin.X, Origin.Y = Size.X*Scale, Size.Y*Scale, Origin.X*Scale, Origin.Y*Scale
-	// chaijun
-	//IsAzurLane = true
-	//Scale = 1.0 / 28.0
-	//Origin.X, Origin.Y = 14703, 11465
-	// kewei
-	IsAzurLane = true
-	Scale = 1.0 / 25.0
-	Origin.X, Origin.Y = 8123, 9365
-	motionManager := NewMotionManager(model)
-	motionManager.PlayMotion("Idle", true)
-	ebiten.SetWindowSize(int(Size.X), int(Size.Y))
+	if len(os.Args) > 1 {
+		RunCommand(os.Args[1], os.Args[2:])
+		return
+	}
+	scene := NewScene()
+	app := NewApp(scene)
+	transform := &Transform{}
+	// 后台加载，加载期间窗口显示进度
+	scene.AddModelAsync(context.Background(), "res/kewei/kewei_4.model3.json", transform, 0, func(instance *Instance, err error) {
+		HandleErr(err)
+		*transform = *NewTransform(instance.MotionManager.Model, 1440, 810) // 宽高限制在 0~1440 0~810
+		// chaijun
+		//transform.IsAzurLane = true
+		//transform.Scale = 1.0 / 28.0
+		//transform.Origin.X, transform.Origin.Y = 14703, 11465
+		// kewei
+		transform.IsAzurLane = true
+		transform.Scale = 1.0 / 25.0
+		transform.Origin.X, transform.Origin.Y = 8123, 9365
+		// 从上次退出时的状态继续，快照损坏时忽略
+		if err := instance.MotionManager.LoadSnapshot(SnapshotFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("ignore snapshot: %v\n", err)
+		}
+		if instance.MotionManager.Motion == nil {
+			instance.MotionManager.PlayMotion("Idle", LoopForever)
+		}
+		ebiten.SetWindowSize(int(transform.Size.X), int(transform.Size.Y))
+		app.OnLoad(instance, err)
+	})
+	ebiten.SetWindowSize(1440, 810)
 	ebiten.SetWindowDecorated(false)
 	ebiten.SetWindowFloating(true)
 	//ebiten.SetWindowMousePassthrough(true)
-	err := ebiten.RunGameWithOptions(NewApp(motionManager),
+	err := ebiten.RunGameWithOptions(app,
 		&ebiten.RunGameOptions{ScreenTransparent: true})
 	HandleErr(err)
+	if len(scene.Instances) > 0 {
+		scene.Instances[0].MotionManager.SaveSnapshot(SnapshotFile)
+	}
 }
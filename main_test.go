@@ -0,0 +1,46 @@
+//go:build display
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// 纹理与绘制只能在游戏循环中进行，需要图形环境的测试都带 display 标签，放在第一帧的 Update 中运行
+// go test -tags display（无显示器时可以用 xvfb-run），不带标签时只运行纯逻辑的测试
+type TestGame struct {
+	M    *testing.M
+	Code int
+}
+
+func (g *TestGame) Update() error {
+	g.Code = g.M.Run()
+	return ebiten.Termination
+}
+
+func (g *TestGame) Draw(screen *ebiten.Image) {
+}
+
+func (g *TestGame) Layout(w, h int) (int, int) {
+	return w, h
+}
+
+func TestMain(m *testing.M) {
+	game := &TestGame{M: m}
+	ebiten.SetWindowSize(64, 64)
+	HandleErr(ebiten.RunGame(game))
+	os.Exit(game.Code)
+}
+
+const TestModelPath = "res/kewei/kewei_4.model3.json"
+
+// 资源没有提交到仓库时跳过
+func LoadTestModel(tb testing.TB) *Model {
+	if _, err := os.Stat(TestModelPath); err != nil {
+		tb.Skip(err)
+	}
+	return LoadModel(TestModelPath)
+}
@@ -4,16 +4,26 @@
 */
 package main
 
-import "github.com/hajimehoshi/ebiten/v2"
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
 
 type Model struct {
-	RootDir         string
+	FS              fs.FS  // 所有资源都从这里读取
+	RootDir         string // model3.json 在 FS 中所在的目录
 	ModelData       *ModelData
 	ExpressionDatas []*ExpressionData1
-	MotionDatas     map[string][]*MotionData1
 	Moc             *Moc
+	Images          map[string]*ebiten.Image // 纹理路径到图片，多个实例共用
 	Drawables       []*Drawable
-	Motions         map[string][]*Motion
+	Parameters      []*Parameter
+	Parts           []*Part
+	Motions         *MotionCache // 按需加载，多个实例共用
 	// 暂时没有用到的数据
 	DisplayData *DisplayData
 	PhysicData  *PhysicData
@@ -22,27 +32,79 @@ type Model struct {
 }
 
 type Moc struct {
-	// 这些 byte空间由 c 占用，不能写入或提前释放
+	// 这些 byte空间由 c 占用，不能写入或提前释放，只能通过 Release 释放
 	Moc       Moc0
 	MocBuff   []byte
 	Model     Model0
 	ModelBuff []byte
+	Refs      *int // 共用 MocBuff 的实例数目
+	Released  bool
+	// 加载时建立的 id 到索引的映射
+	ParameterIdxs map[string]int32
+	PartIdxs      map[string]int32
+	// 指向 c 公共缓存区的视图，通过 handle 直接读写
+	ParameterValues  []float32
+	ParameterMins    []float32
+	ParameterMaxs    []float32
+	ParameterRepeats []int32
+	PartOpacities    []float32
+}
+
+// 参数与部件在公共缓存区中的索引，加载时解析一次
+type (
+	ParameterHandle int32
+	PartHandle      int32
+)
+
+const InvalidHandle = -1
+
+type Parameter struct {
+	Index     int32
+	Id        string
+	Type      int32
+	Minimum   float32
+	Maximum   float32
+	Default   float32
+	Repeat    bool      // 循环参数，超出范围后绕回而不是截断
+	KeyValues []float32 // 关键帧对应的参数值，编辑器可以吸附到这些值上
+}
+
+type Part struct {
+	Index     int32
+	Id        string
+	Name      string // cdi3.json 中的展示名，可能为空
+	Parent    *Part
+	Children  []*Part
+	Drawables []*Drawable // 直接属于该 part 的绘制对象，不含子 part 的
 }
 
 type Drawable struct {
 	// 静态属性
 	Id      string
+	Part    *Part
 	Texture string
 	Image   *ebiten.Image
 	Uvs     []Vector2
 	Idxs    []uint16
 	CFlag   uint8
 	Masks   []uint32
+	MaskDs  []*Drawable // 加载时由 Masks 解析出的遮罩对象
 	// 动态属性，每帧需要更新的属性
 	DFlag   uint8
 	Order   int32
 	Opacity float32
-	Pos     []Vector2
+	Pos     []Vector2 // 指向模型缓存区的视图，释放后置空
+	// 绘制用的顶点缓存，顶点或透明度改变时原地更新
+	Vertexes     []ebiten.Vertex
+	MaskVertexes []ebiten.Vertex // 被用作遮罩时才有，不带透明度
+}
+
+// 模型绘制到屏幕上的变换，模型坐标先按 min(Size.X, Size.Y) 放大再平移到 Origin
+type Transform struct {
+	Size       Vector2
+	Origin     Vector2
+	Scale      float32
+	IsAzurLane bool // 大部分顶点都是 -0.5～0.5 的小数，不过 碧蓝航线 需要特殊处理，平移后还要再乘 Scale
 }
 
 type Vector2 struct {
@@ -55,11 +117,26 @@ type Motion struct {
 	Curves []*Curve
 }
 
+// 所有曲线在区间两端的值都相同时可以直接循环
+func (m *Motion) IsSeamless(start float64, end float64) bool {
+	for _, curve := range m.Curves {
+		value0, ok0 := GetCurveValue(curve, start, nil)
+		value1, ok1 := GetCurveValue(curve, end, nil)
+		if ok0 != ok1 || math.Abs(value0-value1) > 1e-4 {
+			return false
+		}
+	}
+	return true
+}
+
 type Curve struct {
-	Data        *CurveData
-	FadeInTime  float64
-	FadeOutTime float64
-	Segments    []*Segment
+	Data   *CurveData
+	Handle int32 // 根据 Target 为 ParameterHandle 或 PartHandle，id 不存在时为 InvalidHandle
+	// 来自 Meta.AreBeziersRestricted，为 false 时 Bezier 需要用 Cardano 公式求解
+	BeziersRestricted bool
+	FadeInTime        float64
+	FadeOutTime       float64
+	Segments          []*Segment
 }
 
 type Point struct {
@@ -68,7 +145,150 @@ type Point struct {
 }
 
 type Segment struct {
-	Points []*Point
+	Points []*Point // 首尾是这一段的起止点，Bezier 中间还有两个控制点
 	Type   int
-	Value  float64
+	Start  float64
+	End    float64
+}
+
+// 创建共用 moc、纹理与动作数据的新实例，参数与绘制状态各自独立
+func (m *Model) NewInstance() *Model {
+	Assert(!m.Moc.Released, "model already released")
+	moc := m.Moc.NewInstance()
+	ds := GetDrawables(moc.Model, m.ModelData.FileReferences.Textures, m.Images)
+	res := *m
+	res.Moc = moc
+	res.Drawables = ds
+	res.Parts = GetParts(moc.Model, m.DisplayData, ds)
+	return &res
+}
+
+// 释放模型实例，最后一个共用实例释放时一起释放纹理，释放后不能再使用
+func (m *Model) Release() {
+	if m.Moc.Released {
+		return
+	}
+	if m.Moc.Release() {
+		for _, img := range m.Images {
+			img.Deallocate()
+		}
+		// 其他实例都已经释放，清空共用的纹理
+		clear(m.Images)
+		if closer, ok := m.FS.(io.Closer); ok { // zip 文件需要关闭
+			HandleErr(closer.Close())
+		}
+	}
+	m.Images = nil
+	for _, drawable := range m.Drawables {
+		drawable.Pos = nil
+		drawable.Image = nil
+		drawable.Vertexes = nil
+		drawable.MaskVertexes = nil
+	}
+}
+
+func (m *Model) GetMotion(group string, idx int) *Motion {
+	return m.Motions.Get(m.ModelData.FileReferences.Motions[group][idx], m.Moc)
+}
+
+// 提前加载指定的动作组，避免第一次播放时卡顿
+func (m *Model) WarmMotions(groups ...string) {
+	for _, group := range groups {
+		for i := range m.ModelData.FileReferences.Motions[group] {
+			m.GetMotion(group, i)
+		}
+	}
+}
+
+func (m *Model) GetParameter(id string) *Parameter {
+	return m.Parameters[m.Moc.GetParameterIdIndex(id)]
+}
+
+func (m *Model) GetExpression(name string) *ExpressionData1 {
+	for _, expression := range m.ExpressionDatas {
+		if expression.Name == name {
+			return expression
+		}
+	}
+	panic(fmt.Sprintf("expression %s not found", name))
+}
+
+func (m *Model) GetPart(id string) *Part {
+	return m.Parts[m.Moc.GetPartIdIndex(id)]
+}
+
+func (m *Model) GetRootParts() []*Part {
+	res := make([]*Part, 0)
+	for _, part := range m.Parts {
+		if part.Parent == nil {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+func (m *Model) GetPartOpacity(part *Part) float32 {
+	return m.Moc.GetPartOpacity(PartHandle(part.Index))
+}
+
+// recursive 为 true 时整个子树一起设置，用于隐藏或显示一组部件
+func (m *Model) SetPartOpacity(part *Part, value float32, recursive bool) {
+	if !recursive {
+		m.Moc.SetPartOpacity(PartHandle(part.Index), value)
+		return
+	}
+	part.Walk(func(item *Part) {
+		m.Moc.SetPartOpacity(PartHandle(item.Index), value)
+	})
+}
+
+// 获取模型坐标下位于 x,y 的最上层可见绘制对象，可以通过其 Part 找到点击的部件
+func (m *Model) GetDrawableAt(x float32, y float32) *Drawable {
+	var res *Drawable
+	for _, drawable := range m.Drawables {
+		if !HasFlag(drawable.DFlag, DFlagVisible) || drawable.Opacity <= 0 {
+			continue
+		}
+		if res != nil && res.Order > drawable.Order {
+			continue
+		}
+		if drawable.Contains(x, y) {
+			res = drawable
+		}
+	}
+	return res
+}
+
+func (d *Drawable) Contains(x float32, y float32) bool {
+	for i := 0; i+2 < len(d.Idxs); i += 3 {
+		if InTriangle(d.Pos[d.Idxs[i]], d.Pos[d.Idxs[i+1]], d.Pos[d.Idxs[i+2]], x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// 先序遍历子树，包含自身
+func (p *Part) Walk(fn func(part *Part)) {
+	fn(p)
+	for _, child := range p.Children {
+		child.Walk(fn)
+	}
+}
+
+// 整个子树当前顶点的包围盒，模型坐标，没有绘制对象时返回 false
+func (p *Part) GetBounds() (*Vector2, *Vector2, bool) {
+	minPos := &Vector2{X: math.MaxFloat32, Y: math.MaxFloat32}
+	maxPos := &Vector2{X: -math.MaxFloat32, Y: -math.MaxFloat32}
+	ok := false
+	p.Walk(func(part *Part) {
+		for _, drawable := range part.Drawables {
+			for _, pos := range drawable.Pos {
+				minPos.X, minPos.Y = min(minPos.X, pos.X), min(minPos.Y, pos.Y)
+				maxPos.X, maxPos.Y = max(maxPos.X, pos.X), max(maxPos.Y, pos.Y)
+				ok = true
+			}
+		}
+	})
+	return minPos, maxPos, ok
 }
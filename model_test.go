@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing/fstest"
+)
+
+// 不依赖 core 的假模型，参数与部件的值是普通的切片，用于不需要绘制的测试
+// 动作与表情通过 AddTestMotion、AddTestExpression 加入，动作从内存中的 FS 读取
+func NewTestModel(params []*Parameter, parts ...string) *Model {
+	fsys := fstest.MapFS{}
+	moc := &Moc{ParameterIdxs: make(map[string]int32), PartIdxs: make(map[string]int32), Refs: new(int)}
+	*moc.Refs = 1
+	for i, param := range params {
+		param.Index = int32(i)
+		moc.ParameterIdxs[param.Id] = int32(i)
+		moc.ParameterValues = append(moc.ParameterValues, param.Default)
+		moc.ParameterMins = append(moc.ParameterMins, param.Minimum)
+		moc.ParameterMaxs = append(moc.ParameterMaxs, param.Maximum)
+		repeat := int32(0)
+		if param.Repeat {
+			repeat = 1
+		}
+		moc.ParameterRepeats = append(moc.ParameterRepeats, repeat)
+	}
+	res := &Model{FS: fsys, Moc: moc, Parameters: params, Motions: NewMotionCache(fsys, 0),
+		ModelData: &ModelData{FileReferences: &FileReferencesData{Motions: make(map[string][]*MotionData0)}}}
+	for i, id := range parts {
+		moc.PartIdxs[id] = int32(i)
+		moc.PartOpacities = append(moc.PartOpacities, 1)
+		res.Parts = append(res.Parts, &Part{Index: int32(i), Id: id})
+	}
+	return res
+}
+
+// 范围为 -10~10 的普通参数
+func NewTestParameter(id string, def float32) *Parameter {
+	return &Parameter{Id: id, Type: ParameterTypeNormal, Minimum: -10, Maximum: 10, Default: def}
+}
+
+// 写入 FS 并加到 model3.json 的动作组中，返回 FS 中的路径
+func AddTestMotion(model *Model, group string, data *MotionData1) string {
+	refs := model.ModelData.FileReferences
+	file := fmt.Sprintf("motions/%s_%d.motion3.json", group, len(refs.Motions[group]))
+	bs, err := json.Marshal(data)
+	HandleErr(err)
+	model.FS.(fstest.MapFS)[file] = &fstest.MapFile{Data: bs}
+	refs.Motions[group] = append(refs.Motions[group], &MotionData0{File: file})
+	return file
+}
+
+func AddTestExpression(model *Model, name string, params ...*ParameterData1) *ExpressionData1 {
+	res := &ExpressionData1{Name: name, Type: "Live2D Expression", FadeInTime: new(float64), Parameters: params}
+	model.ExpressionDatas = append(model.ExpressionDatas, res)
+	return res
+}
+
+// 每个 id 一条从 0 到 duration 的线性曲线，values 为起止值
+func NewTestMotionData(duration float64, loop bool, curves map[string][2]float64) *MotionData1 {
+	res := &MotionData1{Version: MotionVersion, Meta: &MetaData1{Duration: duration, Fps: 30, Loop: loop}}
+	for id, values := range curves {
+		res.Curves = append(res.Curves, &CurveData{Target: TargetParameter, Id: id,
+			Segments: []float64{0, values[0], CurveLinear, duration, values[1]}})
+	}
+	return res
+}
+
+func NewTestManager(model *Model) *MotionManager {
+	return NewMotionManager(model, &Transform{}, nil)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// 转换后的动作直接按小端序写入，加载时不需要解析 json 与重新拆分曲线
+// 格式：魔数 + 版本 + Meta + Curves(Segments, Points) + UserData，格式改变时增加版本号
+const (
+	MotionBinMagic   = "L2DM"
+	MotionBinVersion = 4
+	MotionBinExt     = ".bin" // xxx.motion3.json 对应 xxx.motion3.bin
+)
+
+func GetMotionBinPath(file string) string {
+	return strings.TrimSuffix(file, ".json") + MotionBinExt
+}
+
+type BinaryWriter struct {
+	Buff bytes.Buffer
+}
+
+func (w *BinaryWriter) Write(data any) {
+	HandleErr(binary.Write(&w.Buff, binary.LittleEndian, data))
+}
+
+func (w *BinaryWriter) WriteStr(str string) {
+	w.Write(uint32(len(str)))
+	w.Buff.WriteString(str)
+}
+
+func (w *BinaryWriter) WriteOptional(val *float64) {
+	w.Write(val != nil)
+	w.Write(ElemOrDef(val, 0))
+}
+
+type BinaryReader struct {
+	Reader *bytes.Reader
+}
+
+func (r *BinaryReader) Read(data any) {
+	HandleErr(binary.Read(r.Reader, binary.LittleEndian, data))
+}
+
+func (r *BinaryReader) ReadU32() uint32 {
+	var res uint32
+	r.Read(&res)
+	return res
+}
+
+func (r *BinaryReader) ReadF64() float64 {
+	var res float64
+	r.Read(&res)
+	return res
+}
+
+func (r *BinaryReader) ReadStr() string {
+	size := r.ReadCount(1)
+	bs := make([]byte, size)
+	_, err := io.ReadFull(r.Reader, bs)
+	HandleErr(err)
+	return string(bs)
+}
+
+// 数目来自文件，每项至少 size 字节，超出剩余数据时说明文件损坏，不能按它分配内存
+func (r *BinaryReader) ReadCount(size int) int {
+	count := r.ReadU32()
+	Assert(int64(count)*int64(size) <= int64(r.Reader.Len()), "invalid count %v", count)
+	return int(count)
+}
+
+func (r *BinaryReader) ReadOptional() *float64 {
+	var ok bool
+	r.Read(&ok)
+	res := r.ReadF64()
+	if !ok {
+		return nil
+	}
+	return &res
+}
+
+func EncodeMotion(motion *Motion) []byte {
+	w := &BinaryWriter{}
+	w.Buff.WriteString(MotionBinMagic)
+	w.Write(uint32(MotionBinVersion))
+	data := motion.Data
+	meta := data.Meta
+	w.Write(int32(data.Version))
+	w.Write(meta.Duration)
+	w.Write(meta.Fps)
+	w.Write(meta.Loop)
+	w.Write(meta.AreBeziersRestricted)
+	w.WriteOptional(meta.FadeInTime)
+	w.WriteOptional(meta.FadeOutTime)
+	for _, count := range []int{meta.CurveCount, meta.TotalSegmentCount, meta.TotalPointCount, meta.UserDataCount, meta.TotalUserDataSize} {
+		w.Write(int32(count))
+	}
+	w.Write(uint32(len(motion.Curves)))
+	for _, curve := range motion.Curves {
+		w.WriteStr(curve.Data.Target)
+		w.WriteStr(curve.Data.Id)
+		w.WriteOptional(curve.Data.FadeInTime)
+		w.WriteOptional(curve.Data.FadeOutTime)
+		w.Write(uint32(len(curve.Segments)))
+		for _, segment := range curve.Segments {
+			w.Write(uint8(segment.Type))
+			w.Write(uint8(len(segment.Points)))
+			for _, point := range segment.Points {
+				w.Write(point.Time)
+				w.Write(point.Value)
+			}
+		}
+		if len(curve.Segments) == 0 { // 只有起始点的曲线没有段，单独保存起始点，写出 json 时需要
+			hasStart := len(curve.Data.Segments) >= 2
+			w.Write(hasStart)
+			if hasStart {
+				w.Write(curve.Data.Segments[:2])
+			}
+		}
+	}
+	w.Write(uint32(len(data.UserData)))
+	for _, item := range data.UserData {
+		w.Write(item.Time)
+		w.WriteStr(item.Value)
+	}
+	return w.Buff.Bytes()
+}
+
+// 每项最少占用的字节数，用于检查读到的数目
+const (
+	MinCurveSize    = 4 + 4 + 9 + 9 + 4 // Target、Id、两个渐变时间、段数
+	MinSegmentSize  = 1 + 1 + 2*16      // 类型、点数、至少两个点
+	MinUserDataSize = 8 + 4
+)
+
+// 魔数或版本不匹配、数据损坏或被截断时返回 false，由调用方改为加载 json
+func DecodeMotion(bs []byte, ref *MotionData0) (*Motion, bool) {
+	if len(bs) < 8 || string(bs[:4]) != MotionBinMagic || binary.LittleEndian.Uint32(bs[4:8]) != MotionBinVersion {
+		return nil, false
+	}
+	var res *Motion
+	err := Try(func() {
+		res = DecodeMotionBody(&BinaryReader{Reader: bytes.NewReader(bs[8:])}, ref)
+	})
+	return res, err == nil
+}
+
+// 读取魔数与版本之后的部分，数据不对时 panic
+func DecodeMotionBody(r *BinaryReader, ref *MotionData0) *Motion {
+	meta := &MetaData1{}
+	var version int32
+	r.Read(&version)
+	r.Read(&meta.Duration)
+	r.Read(&meta.Fps)
+	r.Read(&meta.Loop)
+	r.Read(&meta.AreBeziersRestricted)
+	meta.FadeInTime, meta.FadeOutTime = r.ReadOptional(), r.ReadOptional()
+	counts := make([]int32, 5)
+	r.Read(counts)
+	meta.CurveCount, meta.TotalSegmentCount, meta.TotalPointCount = int(counts[0]), int(counts[1]), int(counts[2])
+	meta.UserDataCount, meta.TotalUserDataSize = int(counts[3]), int(counts[4])
+	data := &MotionData1{Data: ref, Version: int(version), Meta: meta}
+	curves := make([]*Curve, r.ReadCount(MinCurveSize))
+	for i := range curves {
+		// 原始的 Segments 已经转换过了，只有起始点的曲线才保留
+		curveData := &CurveData{Target: r.ReadStr(), Id: r.ReadStr(), FadeInTime: r.ReadOptional(), FadeOutTime: r.ReadOptional()}
+		segments := make([]*Segment, r.ReadCount(MinSegmentSize))
+		for j := range segments {
+			var type0, count uint8
+			r.Read(&type0)
+			r.Read(&count)
+			Assert(type0 <= CurveInverseStepped && count >= 2, "invalid segment type %v point count %v", type0, count)
+			points := make([]*Point, count)
+			for k := range points {
+				points[k] = &Point{Time: r.ReadF64(), Value: r.ReadF64()}
+			}
+			segments[j] = NewSegment(int(type0), points...)
+		}
+		if len(segments) == 0 {
+			var hasStart bool
+			r.Read(&hasStart)
+			if hasStart {
+				curveData.Segments = []float64{r.ReadF64(), r.ReadF64()}
+			}
+		}
+		data.Curves = append(data.Curves, curveData)
+		curves[i] = &Curve{
+			Data:              curveData,
+			Handle:            InvalidHandle,
+			BeziersRestricted: data.Meta.AreBeziersRestricted,
+			FadeInTime:        ElemOrDef(curveData.FadeInTime, -1),
+			FadeOutTime:       ElemOrDef(curveData.FadeOutTime, -1),
+			Segments:          segments,
+		}
+	}
+	count := r.ReadCount(MinUserDataSize)
+	for i := 0; i < count; i++ {
+		data.UserData = append(data.UserData, &UserData3{Time: r.ReadF64(), Value: r.ReadStr()})
+	}
+	return &Motion{Data: data, Curves: curves}
+}
+
+// 二进制文件存在且不比 json 旧时优先使用
+func LoadMotion(fsys fs.FS, ref *MotionData0, moc *Moc) *Motion {
+	binFile := GetMotionBinPath(ref.File)
+	if binInfo, err := fs.Stat(fsys, binFile); err == nil {
+		jsonInfo, err := fs.Stat(fsys, ref.File)
+		if err != nil || !binInfo.ModTime().Before(jsonInfo.ModTime()) {
+			if motion, ok := DecodeMotion(ReadFile(fsys, binFile), ref); ok {
+				BindMotion(motion, moc)
+				return motion
+			}
+		}
+	}
+	return ToMotion(ReadMotionData(fsys, ref), moc)
+}
+
+// 读取 motion3.json 或 Cubism 2 的 .mtn，.mtn 的全局渐变时间保存在 Meta 中
+func ReadMotionData(fsys fs.FS, ref *MotionData0) *MotionData1 {
+	motionData := &MotionData1{}
+	if strings.HasSuffix(ref.File, MtnExt) {
+		var err error
+		motionData, err = ParseMtn(ReadFile(fsys, ref.File))
+		HandleErr(err)
+	} else {
+		UnmarshalFile(fsys, ref.File, motionData)
+	}
+	motionData.Data = ref
+	return motionData
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func NewTestBinMotion() []byte {
+	data := NewTestMotionData(2, false, map[string][2]float64{"ParamA": {0, 1}})
+	data.UserData = []*UserData3{{Time: 1, Value: "event"}}
+	return EncodeMotion(ConvertMotion(data))
+}
+
+// 截断或数目被改坏的文件返回 false，不会 panic，也不会按损坏的数目分配内存
+func TestDecodeCorruptMotion(t *testing.T) {
+	bs := NewTestBinMotion()
+	if _, ok := DecodeMotion(bs, nil); !ok {
+		t.Fatal("decode fail")
+	}
+	for i := 0; i < len(bs); i++ {
+		if _, ok := DecodeMotion(bs[:i], nil); ok {
+			t.Errorf("truncated at %d decoded", i)
+		}
+	}
+	var before, after runtime.MemStats
+	for i := 8; i+4 <= len(bs); i++ {
+		temp := append([]byte(nil), bs...)
+		binary.LittleEndian.PutUint32(temp[i:], 0x7FFFFFFF)
+		runtime.ReadMemStats(&before)
+		DecodeMotion(temp, nil)
+		runtime.ReadMemStats(&after)
+		if size := after.TotalAlloc - before.TotalAlloc; size > 1<<20 {
+			t.Errorf("count at %d: allocated %d bytes", i, size)
+		}
+	}
+}
+
+// 二进制文件损坏时改为加载 json
+func TestLoadCorruptMotion(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0)})
+	file := AddTestMotion(model, "Idle", NewTestMotionData(2, false, map[string][2]float64{"ParamA": {0, 1}}))
+	fsys := model.FS.(fstest.MapFS)
+	bs := NewTestBinMotion()
+	fsys[GetMotionBinPath(file)] = &fstest.MapFile{Data: bs[:len(bs)/2], ModTime: time.Now()}
+	motion := LoadMotion(fsys, model.ModelData.FileReferences.Motions["Idle"][0], model.Moc)
+	if len(motion.Curves) != 1 || motion.Curves[0].Handle != 0 || motion.Data.Meta.Duration != 2 {
+		t.Errorf("fallback motion %+v", motion.Data.Meta)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/fs"
+	"sync"
+	"unsafe"
+)
+
+// 动作在第一次播放时才解析，超出内存预算时淘汰最久没有使用的
+// 同一个 model3.json 的所有实例共用一份，后台加载时也会访问，需要加锁
+// 按 model3.json 中的引用区分，同一个文件被多个动作组引用时渐变时间与声音可能不同，各自解析一份
+type MotionCache struct {
+	Lock   sync.Mutex
+	FS     fs.FS
+	Budget int64 // 内存上限，单位字节，<=0 时不淘汰
+	Size   int64 // 当前缓存动作的估算大小
+	Clock  int64 // 每次访问递增，用于找出最久没有使用的
+	Items  map[*MotionData0]*MotionCacheItem
+}
+
+type MotionCacheItem struct {
+	Motion  *Motion
+	Size    int64
+	LastUse int64
+}
+
+func NewMotionCache(fsys fs.FS, budget int64) *MotionCache {
+	return &MotionCache{FS: fsys, Budget: budget, Items: make(map[*MotionData0]*MotionCacheItem)}
+}
+
+// ref.File 已经是相对 FS 的完整路径，解析失败时 panic
+func (c *MotionCache) Get(ref *MotionData0, moc *Moc) *Motion {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.Clock++
+	if item, ok := c.Items[ref]; ok {
+		item.LastUse = c.Clock
+		return item.Motion
+	}
+	motion := LoadMotion(c.FS, ref, moc)
+	c.put(ref, motion)
+	return motion
+}
+
+func (c *MotionCache) SetBudget(budget int64) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.Budget = budget
+	c.evict(nil)
+}
+
+// 热重载时直接替换，不存在时也加入缓存
+func (c *MotionCache) Put(ref *MotionData0, motion *Motion) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.Clock++
+	c.put(ref, motion)
+}
+
+func (c *MotionCache) put(ref *MotionData0, motion *Motion) {
+	c.remove(ref)
+	item := &MotionCacheItem{Motion: motion, Size: GetMotionSize(motion), LastUse: c.Clock}
+	c.Items[ref] = item
+	c.Size += item.Size
+	c.evict(ref)
+}
+
+func (c *MotionCache) Invalidate(ref *MotionData0) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.remove(ref)
+}
+
+func (c *MotionCache) remove(ref *MotionData0) {
+	if item, ok := c.Items[ref]; ok {
+		c.Size -= item.Size
+		delete(c.Items, ref)
+	}
+}
+
+// 淘汰到预算以内，刚加入的 keep 即使单独超出预算也保留
+// 正在播放的动作被淘汰后 MotionManager 仍然持有，只是下次播放需要重新解析
+func (c *MotionCache) evict(keep *MotionData0) {
+	for c.Budget > 0 && c.Size > c.Budget {
+		var oldest *MotionData0
+		for ref, item := range c.Items {
+			if ref != keep && (oldest == nil || item.LastUse < c.Items[oldest].LastUse) {
+				oldest = ref
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		c.remove(oldest)
+	}
+}
+
+// 按解析后的结构估算占用的内存
+func GetMotionSize(motion *Motion) int64 {
+	res := int64(unsafe.Sizeof(Motion{}))
+	for _, curve := range motion.Curves {
+		res += int64(unsafe.Sizeof(Curve{}))
+		for _, segment := range curve.Segments {
+			res += int64(unsafe.Sizeof(Segment{})) + int64(len(segment.Points))*int64(unsafe.Sizeof(Point{})+8)
+		}
+	}
+	return res
+}
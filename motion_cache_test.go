@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+)
+
+// 两个动作组引用同一个文件，渐变时间与声音不同，缓存中各自一份，热重载时都替换
+func TestMotionCacheSharedFile(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0)})
+	file := AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {0, 1}}))
+	fadeIn := 0.5
+	motions := model.ModelData.FileReferences.Motions
+	motions["Tap"] = append(motions["Tap"], &MotionData0{File: file, FadeInTime: &fadeIn, Sound: "tap.wav"})
+	idle, tap := model.GetMotion("Idle", 0), model.GetMotion("Tap", 0)
+	if idle == tap || idle.Data.Data != motions["Idle"][0] || tap.Data.Data != motions["Tap"][0] {
+		t.Fatalf("motions share the same ref")
+	}
+	if fadeInTime, _ := GetFadeTime(tap.Data); fadeInTime != 0.5 {
+		t.Errorf("tap fade in %v", fadeInTime)
+	}
+	if fadeInTime, _ := GetFadeTime(idle.Data); fadeInTime != 0 {
+		t.Errorf("idle fade in %v", fadeInTime)
+	}
+	if model.GetMotion("Idle", 0) != idle || model.GetMotion("Tap", 0) != tap || len(model.Motions.Items) != 2 {
+		t.Errorf("cache miss, %d items", len(model.Motions.Items))
+	}
+	bs, err := json.Marshal(NewTestMotionData(2, true, map[string][2]float64{"ParamA": {0, 1}}))
+	HandleErr(err)
+	model.FS.(fstest.MapFS)[file] = &fstest.MapFile{Data: bs}
+	reloader := &HotReloader{Scene: &Scene{Models: map[string]*Model{"model": model}}, Path: "model"}
+	reloader.Reload(file)
+	idle, tap = model.GetMotion("Idle", 0), model.GetMotion("Tap", 0)
+	if idle.Data.Meta.Duration != 2 || tap.Data.Meta.Duration != 2 || tap.Data.Data.Sound != "tap.wav" {
+		t.Errorf("after reload: durations %v %v", idle.Data.Meta.Duration, tap.Data.Meta.Duration)
+	}
+}
+
+// 超出预算时淘汰最久没有使用的，刚加入的保留
+func TestMotionCacheEvict(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0)})
+	for i := 0; i < 3; i++ {
+		AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {0, 1}}))
+	}
+	refs := model.ModelData.FileReferences.Motions["Idle"]
+	size := GetMotionSize(model.GetMotion("Idle", 0))
+	model.Motions.SetBudget(size * 2)
+	model.GetMotion("Idle", 1)
+	model.GetMotion("Idle", 0) // 0 比 1 更近使用
+	model.GetMotion("Idle", 2)
+	cache := model.Motions
+	if _, ok := cache.Items[refs[1]]; ok || len(cache.Items) != 2 || cache.Size != size*2 {
+		t.Errorf("items %d size %d", len(cache.Items), cache.Size)
+	}
+	cache.SetBudget(1)
+	if len(cache.Items) != 0 || cache.Size != 0 {
+		t.Errorf("items %d size %d", len(cache.Items), cache.Size)
+	}
+}
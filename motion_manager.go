@@ -7,93 +7,257 @@ package main
 import (
 	"fmt"
 	"image/color"
+	"math"
 	"math/rand"
-	"sort"
+	"slices"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
 type MotionManager struct {
-	Model       *Model
-	Motion      *Motion
-	Timer       float64
-	Loop        bool
-	Shader      *ebiten.Shader
-	AudioPlayer *AudioPlayer
-	// shader中使用的图片必须等大小，这里必须要先把图片绘制到另一个图片上
-	Mask *ebiten.Image
-	Src  *ebiten.Image
-}
-
-func (m *MotionManager) PlayMotion(name string, loop bool) {
-	motions := m.Model.Motions[name]
+	Model           *Model
+	Motion          *Motion
+	Timer           float64 // 动作内的时间，倒放时递减
+	MotionGroup     string  // 通过 PlayMotion 播放时记录，用于保存快照
+	MotionIndex     int
+	Loop            bool    // 由 PlayMotion 的 LoopMode 决定，默认按 Meta.Loop
+	LoopFadeTime    float64 // 首尾不衔接的动作循环时提前多久过渡到开头，0 不过渡
+	Speed           float64 // 播放速度，负数倒放，乒乓循环时到达边界会反向
+	Paused          bool
+	RangeStart      float64 // 播放区间，PlayMotion 时重置为整个动作
+	RangeEnd        float64
+	Seamless        bool               // 区间首尾的值一致，循环时不需要过渡，区间改变时计算
+	PingPong        bool               // 到达区间边界后反向播放，优先于 Loop
+	Elapsed         float64            // 本次播放经过的动作时间，用于渐入
+	Cursors         []int              // 每条曲线上次所在的段，动作数据是共用的，所以记录在这里
+	OnEvent         func(value string) // 经过 UserData 中的时间点时回调，Seek 不触发
+	Expression      *ExpressionData1
+	ExpressionTimer float64           // 表情渐入计时
+	LayerHandles    []ParameterHandle // 表情与覆盖这一帧修改过的参数，下一帧开始时恢复，叠加效果才不会累积
+	LayerValues     []float32         // 修改前的值，其他参数保持动作或应用代码设置的值
+	Overrides       []*Override       // 按优先级排序，最后应用
+	AudioPlayer     *AudioPlayer
+	Transform       *Transform
+	Renderer        *MaskRenderer // 多个模型可以共用
+	// 绘制时复用的数据，避免每帧分配
+	OrderDs         []*Drawable // 按渲染顺序排列，顺序改变时才重新排序
+	OrderChange     bool
+	LastTransform   Transform // 上次计算顶点时的变换
+	TrianglesOption *ebiten.DrawTrianglesOptions
+	ShaderOption    *ebiten.DrawRectShaderOptions
+}
+
+func (m *MotionManager) PlayMotion(name string, mode LoopMode) {
+	motions := m.Model.ModelData.FileReferences.Motions[name]
 	idx := rand.Intn(len(motions))
-	m.Motion = motions[idx] // 有多个动作进行随机
-	m.Timer = 0
-	m.Loop = loop
-	if sound := m.Motion.Data.Data.Sound; len(sound) > 0 {
-		m.AudioPlayer.Play(sound) // 只播放一次
-	}
+	m.StartMotion(m.Model.GetMotion(name, idx), mode) // 有多个动作进行随机
+	m.MotionGroup, m.MotionIndex = name, idx
 	fmt.Printf("name %s idx %d file %s\n", name, idx, m.Motion.Data.Data.File)
 }
 
+// 播放任意动作，例如从其他模型重定向过来的或录制生成的
+func (m *MotionManager) StartMotion(motion *Motion, mode LoopMode) {
+	m.Motion = motion
+	m.MotionGroup, m.MotionIndex = "", -1 // 不是 model3.json 中的动作
+	m.Loop = mode == LoopForever || (mode == LoopAuto && m.Motion.Data.Meta.Loop)
+	m.Paused = false
+	m.RangeStart, m.RangeEnd = 0, m.Motion.Data.Meta.Duration
+	m.Seamless = m.Motion.IsSeamless(m.RangeStart, m.RangeEnd)
+	m.Timer = m.RangeStart
+	if m.Speed < 0 { // 倒放从结尾开始
+		m.Timer = m.RangeEnd
+	}
+	m.Elapsed = 0
+	m.Cursors = nil
+	if ref := m.Motion.Data.Data; ref != nil && len(ref.Sound) > 0 {
+		m.AudioPlayer.Play(ref.Sound) // 只播放一次
+	}
+}
+
 func (m *MotionManager) GetAllMotions() []string {
 	names := make([]string, 0)
-	for name := range m.Model.Motions {
+	for name := range m.Model.ModelData.FileReferences.Motions {
 		names = append(names, name)
 	}
 	return names
 }
 
+func (m *MotionManager) SetSpeed(speed float64) {
+	m.Speed = speed
+}
+
+func (m *MotionManager) Pause() {
+	m.Paused = true
+}
+
+func (m *MotionManager) Resume() {
+	m.Paused = false
+}
+
+// 跳转到动作内的时间，限制在播放区间内，不触发事件也不重置渐入
+func (m *MotionManager) Seek(timer float64) {
+	if m.Motion == nil {
+		return
+	}
+	m.Timer = Clamp(timer, m.RangeStart, m.RangeEnd)
+}
+
+// 只播放动作的一部分，end<=0 时到动作结尾
+func (m *MotionManager) SetRange(start float64, end float64) {
+	if m.Motion == nil {
+		return
+	}
+	duration := m.Motion.Data.Meta.Duration
+	if end <= 0 {
+		end = duration
+	}
+	m.RangeStart = Clamp(start, 0, duration)
+	m.RangeEnd = Clamp(end, m.RangeStart, duration)
+	m.Timer = Clamp(m.Timer, m.RangeStart, m.RangeEnd)
+	m.Seamless = m.Motion.IsSeamless(m.RangeStart, m.RangeEnd)
+}
+
 func (m *MotionManager) StopMotion() {
 	m.Motion = nil
 }
 
+func (m *MotionManager) PlayExpression(name string) {
+	m.Expression = m.Model.GetExpression(name)
+	m.ExpressionTimer = 0
+}
+
+func (m *MotionManager) GetAllExpressions() []string {
+	names := make([]string, 0)
+	for _, expression := range m.Model.ExpressionDatas {
+		names = append(names, expression.Name)
+	}
+	return names
+}
+
+func (m *MotionManager) StopExpression() {
+	m.Expression = nil
+}
+
+// 停止声音并释放模型，之后不能再 Update 或 Draw
+func (m *MotionManager) Release() {
+	if m.AudioPlayer.Audio != nil {
+		m.AudioPlayer.Audio.Paused = true
+	}
+	m.Motion = nil
+	m.Expression = nil
+	m.OrderDs = nil
+	m.Model.Release()
+}
+
 func (m *MotionManager) Update(delta float64) {
-	m.UpdateMotion(delta)
+	Assert(!m.Model.Moc.Released, "model already released")
+	m.UpdateParameters(delta)
 	Update(m.Model.Moc.Model)
 	m.UpdateModel()
 }
 
+// 只计算参数，不需要 core 更新顶点
+func (m *MotionManager) UpdateParameters(delta float64) {
+	m.RestoreLayerValues()
+	m.UpdateMotion(delta)
+	m.UpdateExpression(delta)
+	m.UpdateOverrides(delta) // 物理暂未实现，实现后放在表情与覆盖之间
+}
+
+// 表情与覆盖通过这里修改参数，记下修改前的值
+func (m *MotionManager) SetLayerValue(handle ParameterHandle, value float32) {
+	moc := m.Model.Moc
+	m.LayerHandles = append(m.LayerHandles, handle)
+	m.LayerValues = append(m.LayerValues, moc.GetParameterValue(handle))
+	moc.SetParameterValue(handle, value)
+}
+
+// 倒序恢复，同一个参数被多次修改时回到最早的值
+func (m *MotionManager) RestoreLayerValues() {
+	values := m.Model.Moc.ParameterValues
+	for i := len(m.LayerHandles) - 1; i >= 0; i-- {
+		values[m.LayerHandles[i]] = m.LayerValues[i]
+	}
+	m.LayerHandles, m.LayerValues = m.LayerHandles[:0], m.LayerValues[:0]
+}
+
+func (m *MotionManager) UpdateExpression(delta float64) {
+	if m.Expression == nil {
+		return
+	}
+	m.ExpressionTimer += delta
+	weight := 1.0
+	if fadeInTime := ElemOrDef(m.Expression.FadeInTime, DefaultFadeTime); fadeInTime > 0 {
+		weight = GetEasingSine(m.ExpressionTimer / fadeInTime)
+	}
+	moc := m.Model.Moc
+	for _, item := range m.Expression.Parameters {
+		handle := moc.GetParameterHandle(item.Id)
+		if handle == InvalidHandle {
+			continue
+		}
+		oldValue := moc.GetParameterValue(handle)
+		newValue := BlendExpressionValue(m.Model.Parameters[handle], oldValue, float32(item.Value), float32(weight), item.Blend)
+		m.SetLayerValue(handle, newValue)
+	}
+}
+
 func (m *MotionManager) UpdateMotion(delta float64) {
 	if m.Motion == nil {
 		return
 	}
-	m.Timer += delta
-	if m.Timer > m.Motion.Data.Meta.Duration {
-		if !m.Loop {
-			m.Motion = nil
+	if !m.Paused {
+		m.AdvanceMotion(delta)
+		if m.Motion == nil {
 			return
 		}
-		m.Timer = 0
 	}
-	// 整体的渐入渐出设置
-	fadeIn, fadeOut := GetFade(m.Motion, m.Timer)
-	for _, curve := range m.Motion.Curves {
-		// 每个曲线控制一个部分，一个曲线分为多段，循环获取当前时间对应的段
-		segment := GetRightSegments(curve.Segments, m.Timer)
-		if segment == nil { // 可能没有需要修改的参数
+	// 整体的渐入渐出设置，渐出按距离播放方向上终点的时间计算
+	remaining := math.Inf(1) // 循环时不渐出
+	if !m.Loop && !m.PingPong {
+		remaining = m.RangeEnd - m.Timer
+		if m.Speed < 0 {
+			remaining = m.Timer - m.RangeStart
+		}
+	}
+	fadeIn, fadeOut := GetFade(m.Motion, m.Elapsed, remaining)
+	loopWeight, loopTimer := m.GetLoopFade()
+	moc := m.Model.Moc
+	if len(m.Cursors) != len(m.Motion.Curves) { // 换了动作，重新记录
+		m.Cursors = make([]int, len(m.Motion.Curves))
+	}
+	for i, curve := range m.Motion.Curves {
+		if curve.Handle == InvalidHandle && curve.Data.Target != TargetModel { // moc 中没有对应的 id，可以用 validate 命令检查
 			continue
 		}
-		value := GetSegmentValue(segment, m.Timer)
+		value, ok := GetCurveValue(curve, m.Timer, &m.Cursors[i])
+		if !ok { // 可能没有需要修改的参数
+			continue
+		}
+		if loopWeight > 0 { // 首尾不衔接的循环，结尾逐渐过渡到另一端的值
+			if other, ok := GetCurveValue(curve, loopTimer, nil); ok {
+				value += (other - value) * loopWeight
+			}
+		}
 		switch curve.Data.Target {
 		case TargetPartOpacity:
-			SetPartOpacity(m.Model.Moc.Model, curve.Data.Id, float32(value))
+			moc.SetPartOpacity(PartHandle(curve.Handle), float32(value))
 		case TargetParameter:
-			oldValue := GetParameterValue(m.Model.Moc.Model, curve.Data.Id)
+			handle := ParameterHandle(curve.Handle)
+			oldValue := moc.GetParameterValue(handle)
 			fin, fout := fadeIn, fadeOut // 默认都取全局默认值，我们认为 FadeInTime<0 FadeOutTime<0 是默认值
 			if curve.FadeInTime > 0 {
-				fin = GetEasingSine(m.Timer / curve.FadeInTime)
+				fin = GetEasingSine(m.Elapsed / curve.FadeInTime)
 			} else if curve.FadeInTime == 0 { // 不需要时间直接就是最终状态
 				fin = 1
 			}
 			if curve.FadeOutTime > 0 {
-				fout = GetEasingSine((m.Motion.Data.Meta.Duration - m.Timer) / curve.FadeOutTime)
+				fout = GetEasingSine(remaining / curve.FadeOutTime)
 			} else if curve.FadeOutTime == 0 {
 				fout = 1
 			}
-			newValue := oldValue + float32(fin*fout)*(float32(value)-oldValue)
-			SetParameterValue(m.Model.Moc.Model, curve.Data.Id, newValue)
+			newValue := BlendParameterValue(m.Model.Parameters[handle], oldValue, float32(value), float32(fin*fout))
+			moc.SetParameterValue(handle, newValue)
 		case TargetModel:
 			// TODO
 		default:
@@ -102,137 +266,187 @@ func (m *MotionManager) UpdateMotion(delta float64) {
 	}
 }
 
-func (m *MotionManager) UpdateModel() {
-	dflags := GetDynamicFlags(m.Model.Moc.Model)
-	// 通过动态 flag判断任何一个有改变就就进行一次同步数据
-	drawOrderChange := false
-	renderOrderChange := false
-	opacityChange := false
-	vertexPositionsChange := false
-	for i, dflag := range dflags {
-		m.Model.Drawables[i].DFlag = dflag // 下面有使用，要更新上
-		if HasFlag(dflag, DFlagDrawOrderChange) {
-			drawOrderChange = true
+// 循环时在接近区间边界的 LoopFadeTime 内过渡到另一端，返回权重与另一端的时间
+func (m *MotionManager) GetLoopFade() (float64, float64) {
+	if !m.Loop || m.PingPong || m.LoopFadeTime <= 0 || m.Seamless {
+		return 0, 0
+	}
+	distance, other := m.RangeEnd-m.Timer, m.RangeStart
+	if m.Speed < 0 {
+		distance, other = m.Timer-m.RangeStart, m.RangeEnd
+	}
+	if distance >= m.LoopFadeTime {
+		return 0, 0
+	}
+	return GetEasingSine(1 - distance/m.LoopFadeTime), other
+}
+
+// 按速度推进时间，处理区间边界的循环、乒乓与结束，并触发经过的事件
+func (m *MotionManager) AdvanceMotion(delta float64) {
+	m.Elapsed += delta * math.Abs(m.Speed)
+	last := m.Timer
+	m.Timer += delta * m.Speed
+	start, end := m.RangeStart, m.RangeEnd
+	for m.Motion != nil && (m.Timer > end || m.Timer < start) {
+		if end <= start { // 区间为空，没法循环
+			m.Timer = start
+			break
 		}
-		if HasFlag(dflag, DFlagRenderOrderChange) {
-			renderOrderChange = true
+		bound, next := end, start
+		if m.Timer < start {
+			bound, next = start, end
 		}
-		if HasFlag(dflag, DFlagOpacityChange) {
-			opacityChange = true
+		switch {
+		case m.PingPong: // 反射回区间内并反向，端点上的事件在反向后触发
+			m.FireEvents(last, bound, false)
+			m.Timer = 2*bound - m.Timer
+			m.Speed = -m.Speed
+			last = bound
+		case m.Loop: // 从另一端继续，保留超出的部分
+			m.FireEvents(last, bound, true)
+			m.Timer += next - bound
+			last = next
+		default:
+			m.FireEvents(last, bound, true)
+			m.Timer = bound
+			m.Motion = nil
+			return
 		}
-		if HasFlag(dflag, DFlagVertexPositionChange) {
-			vertexPositionsChange = true
+	}
+	m.FireEvents(last, m.Timer, false)
+}
+
+// 按播放方向触发 [from, to) 之间的事件，倒放时为 (to, from]，closed 时包含 to
+// 相邻的两帧不会重叠，正好在端点上的事件只触发一次
+func (m *MotionManager) FireEvents(from float64, to float64, closed bool) {
+	if m.OnEvent == nil {
+		return
+	}
+	for _, item := range m.Motion.Data.UserData {
+		fire := closed && item.Time == to
+		if from <= to {
+			fire = fire || (item.Time >= from && item.Time < to)
+		} else {
+			fire = fire || (item.Time <= from && item.Time > to)
 		}
-	} // 绘图顺序改变
-	if drawOrderChange || renderOrderChange { // 渲染顺序才是我们需要的
-		orders := GetDrawableRenderOrders(m.Model.Moc.Model)
-		for i, order := range orders {
-			m.Model.Drawables[i].Order = order
+		if fire {
+			m.OnEvent(item.Value)
 		}
-	} // 透明度改变
-	if opacityChange {
-		opacities := GetDrawableOpacities(m.Model.Moc.Model)
-		for i, opacity := range opacities {
-			m.Model.Drawables[i].Opacity = opacity
+	}
+}
+
+func (m *MotionManager) UpdateModel() {
+	model := m.Model.Moc.Model
+	dflags := GetDynamicFlags(model)
+	orders := GetDrawableRenderOrders(model)
+	opacities := GetDrawableOpacities(model)
+	// 只同步有改变的绘制对象，Pos 本身就是 c 缓存区的视图不需要重新获取
+	for i, dflag := range dflags {
+		drawable := m.Model.Drawables[i]
+		drawable.DFlag = dflag // 绘制时有使用，要更新上
+		// 渲染顺序才是我们需要的
+		if HasFlag(dflag, DFlagDrawOrderChange) || HasFlag(dflag, DFlagRenderOrderChange) {
+			drawable.Order = orders[i]
+			m.OrderChange = true
 		}
-	} // 顶点变化
-	if vertexPositionsChange {
-		pos := GetDrawableVertexPositions(m.Model.Moc.Model)
-		for i, item := range pos {
-			m.Model.Drawables[i].Pos = item
+		if HasFlag(dflag, DFlagOpacityChange) || HasFlag(dflag, DFlagVertexPositionChange) {
+			drawable.Opacity = opacities[i]
+			m.UpdateVertexes(drawable)
 		}
 	}
 }
 
 func (m *MotionManager) Draw(screen *ebiten.Image) {
-	// 临时排序进行渲染
-	orderDs := make([]*Drawable, 0)
-	for _, drawable := range m.Model.Drawables {
-		orderDs = append(orderDs, drawable)
-	}
-	sort.Slice(orderDs, func(i, j int) bool {
-		return orderDs[i].Order < orderDs[j].Order
-	})
-	vts := make([][]ebiten.Vertex, 0)
-	for _, drawable := range orderDs {
-		vts = append(vts, m.ToVertexes(drawable))
-	}
-	for i, drawable := range orderDs { // order用法太奇怪了，建议挪出Drawable
+	Assert(!m.Model.Moc.Released, "model already released")
+	if m.LastTransform != *m.Transform { // 变换改变了，所有顶点都要重新计算
+		m.LastTransform = *m.Transform
+		for _, drawable := range m.Model.Drawables {
+			m.UpdateVertexes(drawable)
+		}
+	}
+	bound := screen.Bounds()
+	renderer := m.Renderer
+	renderer.Resize(bound.Dx(), bound.Dy())
+	if m.OrderChange {
+		m.OrderChange = false
+		slices.SortFunc(m.OrderDs, func(a, b *Drawable) int {
+			return int(a.Order - b.Order)
+		})
+	}
+	option := m.TrianglesOption
+	for _, drawable := range m.OrderDs {
 		if !HasFlag(drawable.DFlag, DFlagVisible) {
 			continue
 		}
-		if len(drawable.Masks) > 0 {
-			option := &ebiten.DrawTrianglesOptions{}
+		if len(drawable.MaskDs) > 0 {
 			// 清理 mask 并绘制遮罩
-			m.Mask.Fill(color.RGBA{}) // 使用透明色覆盖
-			for _, mask := range drawable.Masks {
-				temp := m.Model.Drawables[mask]
-				index := GetOrderDsIndex(orderDs, temp.Id)
-				m.Mask.DrawTriangles(vts[index], temp.Idxs, temp.Image, option)
+			renderer.Mask.Fill(color.RGBA{}) // 使用透明色覆盖
+			for _, mask := range drawable.MaskDs {
+				renderer.Mask.DrawTriangles(mask.MaskVertexes, mask.Idxs, mask.Image, option)
 			}
 			// 清理目标纹理 重新绘制目标纹理
-			m.Src.Fill(color.RGBA{})
-			m.Src.DrawTriangles(vts[i], drawable.Idxs, drawable.Image, option)
+			renderer.Src.Fill(color.RGBA{})
+			renderer.Src.DrawTriangles(drawable.Vertexes, drawable.Idxs, drawable.Image, option)
 			// 最终绘制
-			options := &ebiten.DrawRectShaderOptions{}
-			options.Images[0] = m.Src
-			options.Images[1] = m.Mask
-			screen.DrawRectShader(int(Size.X), int(Size.Y), m.Shader, options)
+			m.ShaderOption.Images[0] = renderer.Src
+			m.ShaderOption.Images[1] = renderer.Mask
+			screen.DrawRectShader(bound.Dx(), bound.Dy(), renderer.Shader, m.ShaderOption)
 		} else {
-			option := &ebiten.DrawTrianglesOptions{}
-			option.ColorM.Scale(1, 1, 1, float64(drawable.Opacity))
-			screen.DrawTriangles(vts[i], drawable.Idxs, drawable.Image, option)
-		}
-	}
-}
-
-func GetOrderDsIndex(orderDs []*Drawable, id string) int {
-	for i, drawable := range orderDs {
-		if drawable.Id == id {
-			return i
+			screen.DrawTriangles(drawable.Vertexes, drawable.Idxs, drawable.Image, option)
 		}
 	}
-	panic(fmt.Sprintf("invalid id: %v", id))
 }
 
-func (m *MotionManager) ToVertexes(drawable *Drawable) []ebiten.Vertex {
+// 原地更新顶点缓存，透明度通过顶点颜色传递，作为遮罩时不受透明度影响
+func (m *MotionManager) UpdateVertexes(drawable *Drawable) {
 	bound := drawable.Image.Bounds()
 	w, h := float32(bound.Dx()), float32(bound.Dy())
-	res := make([]ebiten.Vertex, 0)
-	size := min(Size.X, Size.Y)
+	transform := m.Transform
+	size := min(transform.Size.X, transform.Size.Y)
 	for i := 0; i < len(drawable.Pos); i++ {
 		// 主要注意绘图坐标系 y 轴反转
 		// 注意最终图片是绘制出正方形的，而视口是长方形，要进行一定调整
 		// Uvs.XY  0~1
-		if IsAzurLane { // IsAzurLane=true
-			res = append(res, ebiten.Vertex{
-				DstX:   (drawable.Pos[i].X*size + Origin.X) * Scale,
-				DstY:   (-drawable.Pos[i].Y*size + Origin.Y) * Scale,
-				SrcX:   drawable.Uvs[i].X * w,
-				SrcY:   (1 - drawable.Uvs[i].Y) * h,
-				ColorR: 1,
-				ColorG: 1,
-				ColorB: 1,
-				ColorA: 1,
-			})
+		vertex := &drawable.Vertexes[i]
+		if transform.IsAzurLane { // IsAzurLane=true
+			vertex.DstX = (drawable.Pos[i].X*size + transform.Origin.X) * transform.Scale
+			vertex.DstY = (-drawable.Pos[i].Y*size + transform.Origin.Y) * transform.Scale
 		} else { // IsAzurLane=false Pos.XY  -0.5~0.5 一般情况
-			res = append(res, ebiten.Vertex{
-				DstX:   drawable.Pos[i].X*size + Origin.X,
-				DstY:   -drawable.Pos[i].Y*size + Origin.Y,
-				SrcX:   drawable.Uvs[i].X * w,
-				SrcY:   (1 - drawable.Uvs[i].Y) * h,
-				ColorR: 1,
-				ColorG: 1,
-				ColorB: 1,
-				ColorA: 1,
-			})
+			vertex.DstX = drawable.Pos[i].X*size + transform.Origin.X
+			vertex.DstY = -drawable.Pos[i].Y*size + transform.Origin.Y
+		}
+		vertex.SrcX = drawable.Uvs[i].X * w
+		vertex.SrcY = (1 - drawable.Uvs[i].Y) * h
+		vertex.ColorR, vertex.ColorG, vertex.ColorB, vertex.ColorA = 1, 1, 1, drawable.Opacity
+		if drawable.MaskVertexes != nil {
+			drawable.MaskVertexes[i] = *vertex
+			drawable.MaskVertexes[i].ColorA = 1
 		}
 	}
-	return res
 }
 
-func NewMotionManager(model *Model) *MotionManager {
-	return &MotionManager{Model: model,
-		Mask: ebiten.NewImage(int(Size.X), int(Size.Y)), Src: ebiten.NewImage(int(Size.X), int(Size.Y)),
-		Shader: OpenShader("mask.kage"), AudioPlayer: NewAudioPlayer(model.RootDir)}
+// UpdateVertexes 的逆变换，屏幕坐标转为模型坐标
+func (m *MotionManager) ScreenToModel(x float32, y float32) (float32, float32) {
+	transform := m.Transform
+	size := min(transform.Size.X, transform.Size.Y)
+	if transform.IsAzurLane {
+		x, y = x/transform.Scale, y/transform.Scale
+	}
+	return (x - transform.Origin.X) / size, (transform.Origin.Y - y) / size
+}
+
+func NewMotionManager(model *Model, transform *Transform, renderer *MaskRenderer) *MotionManager {
+	orderDs := make([]*Drawable, len(model.Drawables))
+	copy(orderDs, model.Drawables)
+	for _, drawable := range model.Drawables { // 顶点缓存只分配一次
+		drawable.Vertexes = make([]ebiten.Vertex, len(drawable.Pos))
+		for _, mask := range drawable.MaskDs {
+			if mask.MaskVertexes == nil {
+				mask.MaskVertexes = make([]ebiten.Vertex, len(mask.Pos))
+			}
+		}
+	}
+	return &MotionManager{Model: model, Speed: 1, OrderDs: orderDs, OrderChange: true,
+		TrianglesOption: &ebiten.DrawTrianglesOptions{}, ShaderOption: &ebiten.DrawRectShaderOptions{},
+		Transform: transform, Renderer: renderer, AudioPlayer: NewAudioPlayer(model.FS, model.RootDir)}
 }
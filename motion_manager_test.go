@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// 每帧只恢复表情修改过的参数，表情不会累积，应用代码直接设置的参数也不会被改回去
+func TestExpressionLayer(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0), NewTestParameter("ParamB", 0),
+		NewTestParameter("ParamC", 0), NewTestParameter("ParamD", 2)})
+	AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {3, 3}}))
+	AddTestExpression(model, "smile", &ParameterData1{Id: "ParamB", Value: 1, Blend: BlendAdd},
+		&ParameterData1{Id: "ParamD", Value: 2, Blend: BlendMultiply}, &ParameterData1{Id: "ParamX", Value: 1})
+	manager := NewTestManager(model)
+	manager.PlayMotion("Idle", LoopAuto)
+	manager.PlayExpression("smile")
+	moc := model.Moc
+	for i := 0; i < 10; i++ {
+		moc.SetParameterValue(moc.GetParameterHandle("ParamC"), 5)
+		manager.UpdateParameters(1.0 / 60)
+		if got := moc.ParameterValues; got[0] != 3 || got[1] != 1 || got[2] != 5 || got[3] != 4 {
+			t.Fatalf("frame %d: values %v", i, got)
+		}
+	}
+	manager.StopExpression()
+	manager.UpdateParameters(1.0 / 60)
+	if got := moc.ParameterValues; got[0] != 3 || got[1] != 0 || got[2] != 5 || got[3] != 2 {
+		t.Errorf("after stop: values %v", got)
+	}
+}
+
+// 表情的渐入与混合形状参数，覆盖模式下混合形状从默认值开始过渡
+func TestExpressionFade(t *testing.T) {
+	shape := &Parameter{Id: "ParamShape", Type: ParameterTypeBlendShape, Minimum: 0, Maximum: 1, Default: 0}
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0), shape})
+	expression := AddTestExpression(model, "fade", &ParameterData1{Id: "ParamA", Value: 2, Blend: BlendAdd},
+		&ParameterData1{Id: "ParamShape", Value: 1, Blend: BlendOverwrite})
+	fadeInTime := 1.0
+	expression.FadeInTime = &fadeInTime
+	manager := NewTestManager(model)
+	manager.PlayExpression("fade")
+	moc := model.Moc
+	moc.ParameterValues[1] = 0.8 // 上一帧动作留下的形状权重不参与混合
+	manager.UpdateParameters(0.5)
+	if got := moc.ParameterValues; math.Abs(float64(got[0])-1) > 1e-6 || math.Abs(float64(got[1])-0.5) > 1e-6 {
+		t.Errorf("half faded: values %v", got)
+	}
+	manager.UpdateParameters(0.5)
+	if got := moc.ParameterValues; got[0] != 2 || got[1] != 1 {
+		t.Errorf("faded in: values %v", got)
+	}
+}
+
+// 事件正好在区间端点上时，循环、往返与播放一次都只触发一次
+func TestMotionEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     LoopMode
+		pingPong bool
+		frames   int
+		want     []string
+	}{
+		{"once", LoopOnce, false, 6, []string{"start", "mid", "end"}},
+		{"loop", LoopForever, false, 9, []string{"start", "mid", "end", "start", "mid", "end", "start"}},
+		{"ping pong", LoopOnce, true, 9, []string{"start", "mid", "end", "mid", "start"}},
+	}
+	for _, test := range tests {
+		model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0)})
+		data := NewTestMotionData(1, false, map[string][2]float64{"ParamA": {0, 1}})
+		data.UserData = []*UserData3{{Time: 0, Value: "start"}, {Time: 0.5, Value: "mid"}, {Time: 1, Value: "end"}}
+		AddTestMotion(model, "Idle", data)
+		manager := NewTestManager(model)
+		got := make([]string, 0)
+		manager.OnEvent = func(value string) {
+			got = append(got, value)
+		}
+		manager.PlayMotion("Idle", test.mode)
+		manager.PingPong = test.pingPong
+		for i := 0; i < test.frames; i++ {
+			manager.UpdateParameters(0.25)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// 热重载后动作变长，原来播放整个动作时区间跟着变长，只播放一部分时保持不变
+func TestReloadMotionRange(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0)})
+	file := AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {0, 1}}))
+	full, part := NewTestManager(model), NewTestManager(model)
+	full.PlayMotion("Idle", LoopAuto)
+	part.PlayMotion("Idle", LoopAuto)
+	part.SetRange(0.25, 0.75)
+	scene := &Scene{Models: map[string]*Model{"model": model},
+		Instances: []*Instance{{Path: "model", MotionManager: full}, {Path: "model", MotionManager: part}}}
+	bs, err := json.Marshal(NewTestMotionData(2, true, map[string][2]float64{"ParamA": {0, 1}}))
+	HandleErr(err)
+	model.FS.(fstest.MapFS)[file] = &fstest.MapFile{Data: bs}
+	reloader := &HotReloader{Scene: scene, Path: "model"}
+	reloader.ReloadMotion(model.ModelData.FileReferences.Motions["Idle"][0])
+	if full.Motion.Data.Meta.Duration != 2 || full.RangeStart != 0 || full.RangeEnd != 2 {
+		t.Errorf("full range: duration %v range %v-%v", full.Motion.Data.Meta.Duration, full.RangeStart, full.RangeEnd)
+	}
+	if part.RangeStart != 0.25 || part.RangeEnd != 0.75 {
+		t.Errorf("part range: %v-%v", part.RangeStart, part.RangeEnd)
+	}
+}
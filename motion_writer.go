@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// 把转换后的动作还原为 motion3.json 的结构，Meta 中的数目按实际内容重新统计
+// 写出的结果可以直接被官方 framework 与 LoadModel 读取
+func ToMotionData(motion *Motion) *MotionData1 {
+	meta := *motion.Data.Meta
+	res := &MotionData1{Data: motion.Data.Data, Version: MotionVersion, Meta: &meta, UserData: motion.Data.UserData}
+	meta.TotalSegmentCount, meta.TotalPointCount = 0, 0
+	for _, curve := range motion.Curves {
+		curveData := &CurveData{
+			Target:      curve.Data.Target,
+			Id:          curve.Data.Id,
+			FadeInTime:  curve.Data.FadeInTime,
+			FadeOutTime: curve.Data.FadeOutTime,
+			Segments:    ToSegmentsData(curve.Segments),
+		}
+		if len(curve.Segments) == 0 { // 只有起始点的曲线转换后没有段，使用原始数据
+			curveData.Segments = curve.Data.Segments
+		}
+		if len(curveData.Segments) == 0 {
+			continue
+		}
+		res.Curves = append(res.Curves, curveData)
+		meta.TotalSegmentCount += len(curve.Segments)
+		meta.TotalPointCount++ // 起始点
+		for _, segment := range curve.Segments {
+			meta.TotalPointCount += len(segment.Points) - 1
+		}
+	}
+	meta.CurveCount = len(res.Curves)
+	meta.UserDataCount = len(res.UserData)
+	meta.TotalUserDataSize = 0
+	for _, item := range res.UserData {
+		meta.TotalUserDataSize += len(item.Value)
+	}
+	return res
+}
+
+// 第一段的起点后接每一段的类型与除起点外的其余点
+func ToSegmentsData(segments []*Segment) []float64 {
+	if len(segments) == 0 {
+		return nil
+	}
+	start := segments[0].Points[0]
+	res := []float64{start.Time, start.Value}
+	for _, segment := range segments {
+		res = append(res, float64(segment.Type))
+		for _, point := range segment.Points[1:] {
+			res = append(res, point.Time, point.Value)
+		}
+	}
+	return res
+}
+
+func MarshalMotion(motion *Motion) []byte {
+	bs, err := json.MarshalIndent(ToMotionData(motion), "", "\t")
+	HandleErr(err)
+	return bs
+}
+
+func SaveMotion(file string, motion *Motion) {
+	HandleErr(os.WriteFile(file, MarshalMotion(motion), 0644))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// res 下所有动作写出再读回，曲线、Meta 与 UserData 都不变
+func TestMarshalMotionRoundTrip(t *testing.T) {
+	fsys := os.DirFS("res")
+	files := make([]string, 0)
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(path, ".motion3.json") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			motionData := &MotionData1{}
+			UnmarshalFile(fsys, file, motionData)
+			want := ToMotionData(ConvertMotion(motionData))
+			res := &MotionData1{}
+			if err := json.Unmarshal(MarshalMotion(ConvertMotion(motionData)), res); err != nil {
+				t.Fatal(err)
+			}
+			if got := ToMotionData(ConvertMotion(res)); !reflect.DeepEqual(got, want) {
+				t.Errorf("json round trip changed %s", file)
+			}
+			decoded, ok := DecodeMotion(EncodeMotion(ConvertMotion(motionData)), nil)
+			if !ok {
+				t.Fatal("decode fail")
+			}
+			if got := ToMotionData(decoded); !reflect.DeepEqual(got, want) {
+				t.Errorf("bin round trip changed %s", file)
+			}
+		})
+	}
+}
+
+// 只有起始点的曲线没有段，二进制格式也要保留起始点
+func TestStartOnlyCurve(t *testing.T) {
+	motionData := &MotionData1{Version: MotionVersion, Meta: &MetaData1{Duration: 1, Fps: 30}, Curves: []*CurveData{
+		{Target: TargetParameter, Id: "ParamA", Segments: []float64{0, 0.5}},
+		{Target: TargetParameter, Id: "ParamB", Segments: []float64{0, 0, CurveLinear, 1, 1}},
+	}}
+	decoded, ok := DecodeMotion(EncodeMotion(ConvertMotion(motionData)), nil)
+	if !ok {
+		t.Fatal("decode fail")
+	}
+	res := ToMotionData(decoded)
+	if len(res.Curves) != 2 || !reflect.DeepEqual(res.Curves[0].Segments, []float64{0, 0.5}) {
+		t.Errorf("start only curve lost: %v", res.Curves)
+	}
+	if res.Meta.CurveCount != 2 || res.Meta.TotalPointCount != 3 {
+		t.Errorf("meta %+v", res.Meta)
+	}
+}
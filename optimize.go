@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// 导出的动作经常每帧一个线性关键帧，这里在误差范围内用更少的段重新拟合
+// 阶梯与原有的 Bezier 段保持不变，只处理连续的线性段
+type OptimizeResult struct {
+	OldPoints     int
+	NewPoints     int
+	RemovedCurves int
+}
+
+// maxError 为相对参数范围的比例，部件不透明度的范围为 1
+func OptimizeMotion(motion *Motion, moc *Moc, params []*Parameter, maxError float64) *OptimizeResult {
+	res := &OptimizeResult{OldPoints: ToMotionData(motion).Meta.TotalPointCount}
+	curves := make([]*Curve, 0)
+	for _, curve := range motion.Curves {
+		size, def, ok := GetCurveRange(curve, moc, params)
+		if !ok || len(curve.Segments) == 0 { // 不认识的曲线原样保留
+			curves = append(curves, curve)
+			continue
+		}
+		tolerance := maxError * size
+		if IsCurveConstant(curve, def, tolerance) { // 一直是默认值，不需要这条曲线
+			res.RemovedCurves++
+			continue
+		}
+		curve.Segments = OptimizeSegments(curve.Segments, tolerance)
+		curves = append(curves, curve)
+	}
+	motion.Curves = curves
+	res.NewPoints = ToMotionData(motion).Meta.TotalPointCount
+	return res
+}
+
+// 返回曲线目标的范围与默认值
+func GetCurveRange(curve *Curve, moc *Moc, params []*Parameter) (float64, float64, bool) {
+	switch curve.Data.Target {
+	case TargetParameter:
+		handle := moc.GetParameterHandle(curve.Data.Id)
+		if handle == InvalidHandle {
+			return 0, 0, false
+		}
+		param := params[handle]
+		return float64(param.Maximum - param.Minimum), float64(param.Default), true
+	case TargetPartOpacity:
+		handle := moc.GetPartHandle(curve.Data.Id)
+		if handle == InvalidHandle {
+			return 0, 0, false
+		}
+		return 1, float64(moc.PartOpacities[handle]), true // 还没有 Update 过，就是默认值
+	}
+	return 0, 0, false
+}
+
+func IsCurveConstant(curve *Curve, value float64, tolerance float64) bool {
+	for _, segment := range curve.Segments {
+		for _, point := range segment.Points {
+			if math.Abs(point.Value-value) > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func OptimizeSegments(segments []*Segment, tolerance float64) []*Segment {
+	res := make([]*Segment, 0)
+	points := make([]*Point, 0) // 连续线性段的点
+	flush := func() {
+		if len(points) > 1 {
+			res = append(res, FitPoints(points, tolerance)...)
+		}
+		points = nil
+	}
+	for _, segment := range segments {
+		if segment.Type != CurveLinear {
+			flush()
+			res = append(res, segment)
+			continue
+		}
+		if len(points) == 0 {
+			points = append(points, segment.Points[0])
+		}
+		points = append(points, segment.Points[1])
+	}
+	flush()
+	return res
+}
+
+// 先用 RDP 简化为线性段，再把多个相邻的线性段尽量合并为一个 Bezier（一个 Bezier 占 3 个点）
+func FitPoints(points []*Point, tolerance float64) []*Segment {
+	idxs := make(map[*Point]int)
+	for i, point := range points {
+		idxs[point] = i
+	}
+	keeps := SimplifyTrack(points, tolerance)
+	res := make([]*Segment, 0)
+	for a := 0; a < len(keeps)-1; {
+		var bezier *Segment
+		next := a + 1
+		for b := a + 4; b < len(keeps); b++ {
+			segment, ok := FitBezier(points[idxs[keeps[a]]:idxs[keeps[b]]+1], tolerance)
+			if !ok {
+				break
+			}
+			bezier, next = segment, b
+		}
+		if bezier != nil {
+			res = append(res, bezier)
+		} else {
+			res = append(res, NewSegment(CurveLinear, keeps[a], keeps[a+1]))
+		}
+		a = next
+	}
+	return res
+}
+
+// 控制点时间固定在三等分处，这样不论 AreBeziersRestricted 是什么都能正确求值
+// 首尾值固定，最小二乘求两个控制点的值，所有点的误差都在范围内才算成功
+func FitBezier(points []*Point, tolerance float64) (*Segment, bool) {
+	p0, p3 := points[0], points[len(points)-1]
+	duration := p3.Time - p0.Time
+	if duration <= 0 {
+		return nil, false
+	}
+	bases := func(point *Point) (float64, float64, float64, float64) {
+		t := Clamp((point.Time-p0.Time)/duration, 0, 1)
+		u := 1 - t
+		return u * u * u, 3 * u * u * t, 3 * u * t * t, t * t * t
+	}
+	var a11, a12, a22, r1, r2 float64
+	for _, point := range points[1 : len(points)-1] {
+		b0, b1, b2, b3 := bases(point)
+		r := point.Value - b0*p0.Value - b3*p3.Value
+		a11 += b1 * b1
+		a12 += b1 * b2
+		a22 += b2 * b2
+		r1 += b1 * r
+		r2 += b2 * r
+	}
+	det := a11*a22 - a12*a12
+	if math.Abs(det) < 1e-12 {
+		return nil, false
+	}
+	c1 := (r1*a22 - r2*a12) / det
+	c2 := (a11*r2 - a12*r1) / det
+	// 原来的点之间是直线，关键帧稀疏时只检查关键帧不够，每段中间也要检查
+	for i := 1; i < len(points); i++ {
+		for j := 0; j < FitCheckCount; j++ {
+			point := LerpPoint(points[i-1], points[i], float64(j)/FitCheckCount)
+			b0, b1, b2, b3 := bases(point)
+			if math.Abs(b0*p0.Value+b1*c1+b2*c2+b3*p3.Value-point.Value) > tolerance {
+				return nil, false
+			}
+		}
+	}
+	return NewSegment(CurveBezier, p0,
+		&Point{Time: p0.Time + duration/3, Value: c1},
+		&Point{Time: p0.Time + duration*2/3, Value: c2}, p3), true
+}
+
+func (r *OptimizeResult) String() string {
+	return fmt.Sprintf("points %d -> %d, removed %d curves", r.OldPoints, r.NewPoints, r.RemovedCurves)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// 每帧一个线性关键帧的动作，ParamA 为正弦，ParamB 一直是默认值，ParamC 带一段阶梯，PartA 渐隐
+func NewOptimizeTestData(restricted bool) *MotionData1 {
+	data := &MotionData1{Version: MotionVersion, Meta: &MetaData1{Duration: 3, Fps: 30, AreBeziersRestricted: restricted}}
+	sine, flat, step, fade := []float64{0, 0}, []float64{0, 1}, []float64{0, 2, CurveStepped, 1, -2}, []float64{0, 1}
+	for i := 1; i <= 90; i++ {
+		time := float64(i) / 30
+		sine = append(sine, CurveLinear, time, 8*math.Sin(time*2))
+		flat = append(flat, CurveLinear, time, 1+0.001*float64(i%2))
+		if time > 1 {
+			step = append(step, CurveLinear, time, -2+time)
+		}
+		fade = append(fade, CurveLinear, time, 1-time/3)
+	}
+	data.Curves = []*CurveData{
+		{Target: TargetParameter, Id: "ParamA", Segments: sine},
+		{Target: TargetParameter, Id: "ParamB", Segments: flat},
+		{Target: TargetParameter, Id: "ParamC", Segments: step},
+		{Target: TargetPartOpacity, Id: "PartA", Segments: fade},
+		{Target: TargetParameter, Id: "ParamX", Segments: []float64{0, 0, CurveLinear, 3, 1}},
+	}
+	return data
+}
+
+// 重新拟合后的曲线在关键帧与关键帧之间都与原来的误差在 maxError * 范围内
+func TestOptimizeMotion(t *testing.T) {
+	params := []*Parameter{NewTestParameter("ParamA", 0), NewTestParameter("ParamB", 1), NewTestParameter("ParamC", 0)}
+	moc := NewTestModel(params, "PartA").Moc
+	for _, restricted := range []bool{true, false} {
+		for _, maxError := range []float64{0.0001, 0.001, 0.01} {
+			origin := ToMotion(NewOptimizeTestData(restricted), moc)
+			motion := ToMotion(NewOptimizeTestData(restricted), moc)
+			res := OptimizeMotion(motion, moc, params, maxError)
+			if res.RemovedCurves != 1 || len(motion.Curves) != 4 || res.NewPoints >= res.OldPoints {
+				t.Fatalf("restricted %v error %v: %v, %d curves", restricted, maxError, res, len(motion.Curves))
+			}
+			if motion.Curves[3].Data.Id != "ParamX" || len(motion.Curves[3].Segments) != 1 { // 模型中没有的曲线原样保留
+				t.Errorf("restricted %v error %v: unknown curve changed", restricted, maxError)
+			}
+			if segment := motion.Curves[1].Segments[0]; segment.Type != CurveStepped {
+				t.Errorf("restricted %v error %v: stepped segment type %d", restricted, maxError, segment.Type)
+			}
+			for i, curve := range motion.Curves[:3] {
+				src := origin.Curves[i]
+				if i > 0 {
+					src = origin.Curves[i+1] // ParamB 被删除了
+				}
+				size, _, _ := GetCurveRange(src, moc, params)
+				for timer := 0.0; timer <= 3; timer += 1.0 / 300 {
+					want, _ := GetCurveValue(src, timer, nil)
+					got, ok := GetCurveValue(curve, timer, nil)
+					if !ok || math.Abs(got-want) > maxError*size+1e-6 {
+						t.Fatalf("restricted %v error %v %s %v: got %v want %v", restricted, maxError, curve.Data.Id, timer, got, want)
+					}
+				}
+			}
+		}
+	}
+}
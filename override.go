@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 应用代码固定某个参数，例如一直脸红，在动作与表情之后按优先级从低到高依次混合
+// 每帧都会先恢复被覆盖参数原来的值，移除后参数自然回到动作控制
+type Override struct {
+	Source   string // 谁设置的，同一来源同一参数只保留一个
+	Id       string
+	Handle   ParameterHandle
+	Value    float32
+	Weight   float32 // 0~1，与下层结果的混合比例
+	Priority int     // 越大越后应用
+	Timeout  float64 // 剩余时间，<=0 时一直有效
+}
+
+// 参数不存在时返回错误，已有的覆盖保持不变
+func (m *MotionManager) SetOverride(source string, id string, value float32, weight float32, priority int, timeout float64) (*Override, error) {
+	handle := m.Model.Moc.GetParameterHandle(id)
+	if handle == InvalidHandle {
+		return nil, fmt.Errorf("parameter %s not found", id)
+	}
+	m.ReleaseOverride(source, id)
+	res := &Override{Source: source, Id: id, Handle: handle, Value: value, Weight: Clamp(weight, 0, 1),
+		Priority: priority, Timeout: timeout}
+	m.Overrides = append(m.Overrides, res)
+	slices.SortStableFunc(m.Overrides, func(a, b *Override) int {
+		return a.Priority - b.Priority
+	})
+	return res, nil
+}
+
+func (m *MotionManager) ReleaseOverride(source string, id string) {
+	m.Overrides = slices.DeleteFunc(m.Overrides, func(item *Override) bool {
+		return item.Source == source && item.Id == id
+	})
+}
+
+// 释放某个来源设置的所有参数
+func (m *MotionManager) ReleaseOverrides(source string) {
+	m.Overrides = slices.DeleteFunc(m.Overrides, func(item *Override) bool {
+		return item.Source == source
+	})
+}
+
+func (m *MotionManager) UpdateOverrides(delta float64) {
+	m.Overrides = slices.DeleteFunc(m.Overrides, func(item *Override) bool {
+		if item.Timeout <= 0 {
+			return false
+		}
+		item.Timeout -= delta
+		return item.Timeout <= 0
+	})
+	moc := m.Model.Moc
+	for _, item := range m.Overrides {
+		oldValue := moc.GetParameterValue(item.Handle)
+		newValue := BlendParameterValue(m.Model.Parameters[item.Handle], oldValue, item.Value, item.Weight)
+		m.SetLayerValue(item.Handle, newValue)
+	}
+}
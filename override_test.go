@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// ParamA 由动作固定为 2，ParamB 没有动作控制
+func NewOverrideTestManager() *MotionManager {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0), NewTestParameter("ParamB", 1)})
+	AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {2, 2}}))
+	manager := NewTestManager(model)
+	manager.PlayMotion("Idle", LoopForever)
+	return manager
+}
+
+func CheckValues(t *testing.T, name string, manager *MotionManager, want ...float32) {
+	for i, value := range want {
+		if got := manager.Model.Moc.ParameterValues[i]; math.Abs(float64(got-value)) > 1e-6 {
+			t.Errorf("%s: param %d got %v want %v", name, i, got, value)
+		}
+	}
+}
+
+func TestOverridePriority(t *testing.T) {
+	manager := NewOverrideTestManager()
+	manager.SetOverride("high", "ParamA", 8, 1, 10, 0)
+	manager.SetOverride("low", "ParamA", 4, 1, 0, 0)
+	manager.SetOverride("low2", "ParamA", 6, 1, 0, 0) // 同优先级按设置的顺序应用
+	if manager.Overrides[0].Source != "low" || manager.Overrides[1].Source != "low2" || manager.Overrides[2].Source != "high" {
+		t.Fatalf("order %v %v %v", manager.Overrides[0].Source, manager.Overrides[1].Source, manager.Overrides[2].Source)
+	}
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "priority", manager, 8, 1)
+	manager.ReleaseOverride("high", "ParamA")
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "release high", manager, 6, 1)
+	manager.SetOverride("low", "ParamA", 5, 1, 20, 0) // 同一来源同一参数只保留一个
+	if len(manager.Overrides) != 2 {
+		t.Fatalf("overrides %d", len(manager.Overrides))
+	}
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "replace", manager, 5, 1)
+}
+
+// 与下层的结果按权重混合，多层依次混合
+func TestOverrideWeight(t *testing.T) {
+	manager := NewOverrideTestManager()
+	manager.SetOverride("a", "ParamA", 6, 0.5, 0, 0)
+	manager.SetOverride("b", "ParamA", 0, 0.25, 1, 0)
+	manager.SetOverride("c", "ParamB", 3, 2, 0, 0) // 权重限制在 0~1
+	for i := 0; i < 3; i++ {                       // 不会逐帧累积
+		manager.UpdateParameters(0.1)
+		CheckValues(t, "weight", manager, 3, 3)
+	}
+}
+
+// 超时后移除，参数回到动作或原来的值
+func TestOverrideTimeout(t *testing.T) {
+	manager := NewOverrideTestManager()
+	manager.SetOverride("blush", "ParamA", 9, 1, 0, 0.25)
+	manager.SetOverride("blush", "ParamB", 9, 1, 0, 0.25)
+	manager.SetOverride("app", "ParamB", 7, 1, -1, 0)
+	manager.UpdateParameters(0.1)
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "active", manager, 9, 9)
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "expired", manager, 2, 7)
+	if len(manager.Overrides) != 1 || manager.Overrides[0].Source != "app" {
+		t.Errorf("overrides %+v", manager.Overrides)
+	}
+	manager.ReleaseOverrides("app")
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "released", manager, 2, 1)
+}
+
+// 释放后参数回到动作控制
+func TestReleaseOverride(t *testing.T) {
+	manager := NewOverrideTestManager()
+	manager.SetOverride("app", "ParamA", -5, 1, 0, 0)
+	manager.SetOverride("app", "ParamB", -5, 1, 0, 0)
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "override", manager, -5, -5)
+	manager.ReleaseOverrides("app")
+	manager.UpdateParameters(0.1)
+	CheckValues(t, "release", manager, 2, 1)
+}
+
+func TestOverrideUnknownId(t *testing.T) {
+	manager := NewOverrideTestManager()
+	manager.SetOverride("app", "ParamA", 1, 1, 0, 0)
+	if res, err := manager.SetOverride("app", "ParamX", 1, 1, 0, 0); err == nil || res != nil {
+		t.Errorf("unknown id: %v %v", res, err)
+	}
+	if len(manager.Overrides) != 1 {
+		t.Errorf("overrides %d", len(manager.Overrides))
+	}
+}
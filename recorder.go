@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+)
+
+// 录制参数与部件不透明度的变化，例如鼠标跟随、滑条或外部面捕驱动的结果，保存为 motion3.json
+// 每帧在 MotionManager.Update 之后调用 Record，直接读取 core 中的数据
+type Recorder struct {
+	Model           *Model
+	Fps             float64 // 写入 Meta.Fps，只影响编辑器显示
+	Tolerance       float64 // 简化时允许的最大误差，相对参数范围的比例
+	Timer           float64
+	ParameterTracks [][]*Point
+	PartTracks      [][]*Point
+}
+
+func NewRecorder(model *Model, tolerance float64) *Recorder {
+	return &Recorder{
+		Model:           model,
+		Fps:             30,
+		Tolerance:       tolerance,
+		ParameterTracks: make([][]*Point, len(model.Parameters)),
+		PartTracks:      make([][]*Point, len(model.Parts)),
+	}
+}
+
+// 记录当前帧的值，delta 为到下一帧的时间
+func (r *Recorder) Record(delta float64) {
+	moc := r.Model.Moc
+	for i, value := range moc.ParameterValues {
+		r.ParameterTracks[i] = append(r.ParameterTracks[i], &Point{Time: r.Timer, Value: float64(value)})
+	}
+	for i, value := range moc.PartOpacities {
+		r.PartTracks[i] = append(r.PartTracks[i], &Point{Time: r.Timer, Value: float64(value)})
+	}
+	r.Timer += delta
+}
+
+// 只保留有变化的轨迹，并简化为线性段
+func (r *Recorder) ToMotion() *Motion {
+	duration := 0.0
+	if len(r.ParameterTracks) > 0 && len(r.ParameterTracks[0]) > 0 {
+		duration = r.ParameterTracks[0][len(r.ParameterTracks[0])-1].Time
+	}
+	data := &MotionData1{Version: MotionVersion, Meta: &MetaData1{Duration: duration, Fps: r.Fps, AreBeziersRestricted: true}}
+	curves := make([]*Curve, 0)
+	addCurve := func(target string, id string, track []*Point, size float64) {
+		if !IsTrackChanged(track) {
+			return
+		}
+		points := SimplifyTrack(track, r.Tolerance*size)
+		segments := make([]*Segment, 0)
+		for i := 1; i < len(points); i++ {
+			segments = append(segments, NewSegment(CurveLinear, points[i-1], points[i]))
+		}
+		curveData := &CurveData{Target: target, Id: id}
+		data.Curves = append(data.Curves, curveData)
+		curves = append(curves, &Curve{Data: curveData, Handle: InvalidHandle, BeziersRestricted: true,
+			FadeInTime: -1, FadeOutTime: -1, Segments: segments})
+	}
+	for i, param := range r.Model.Parameters {
+		addCurve(TargetParameter, param.Id, r.ParameterTracks[i], float64(param.Maximum-param.Minimum))
+	}
+	for i, part := range r.Model.Parts {
+		addCurve(TargetPartOpacity, part.Id, r.PartTracks[i], 1)
+	}
+	motion := &Motion{Data: data, Curves: curves}
+	BindMotion(motion, r.Model.Moc)
+	return motion
+}
+
+func (r *Recorder) Save(file string) {
+	SaveMotion(file, r.ToMotion())
+}
+
+func IsTrackChanged(track []*Point) bool {
+	for _, point := range track {
+		if point.Value != track[0].Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Ramer–Douglas–Peucker，距离取同一时间上的值差，保留首尾点
+func SimplifyTrack(track []*Point, tolerance float64) []*Point {
+	if len(track) < 3 {
+		return track
+	}
+	first, last := track[0], track[len(track)-1]
+	maxDist, maxIdx := 0.0, 0
+	span := last.Time - first.Time
+	for i := 1; i < len(track)-1; i++ {
+		rate := 0.0 // 时间相同的点（例如 delta 为 0 的帧）按起点比较
+		if span > 0 {
+			rate = (track[i].Time - first.Time) / span
+		}
+		dist := math.Abs(track[i].Value - LerpPoint(first, last, rate).Value)
+		if dist > maxDist {
+			maxDist, maxIdx = dist, i
+		}
+	}
+	if maxDist <= tolerance {
+		return []*Point{first, last}
+	}
+	left := SimplifyTrack(track[:maxIdx+1], tolerance)
+	right := SimplifyTrack(track[maxIdx:], tolerance)
+	return append(left[:len(left)-1:len(left)-1], right...)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// 按简化后的线段在原来每个点的时间上求值
+func TrackValue(points []*Point, time float64) float64 {
+	for i := 1; i < len(points); i++ {
+		if time <= points[i].Time {
+			return LerpPoint(points[i-1], points[i], (time-points[i-1].Time)/(points[i].Time-points[i-1].Time)).Value
+		}
+	}
+	return points[len(points)-1].Value
+}
+
+func TestSimplifyTrack(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	track := make([]*Point, 0)
+	for i := 0; i < 300; i++ {
+		time := float64(i) / 30
+		track = append(track, &Point{Time: time, Value: 5*math.Sin(time*3) + r.Float64()*0.2})
+	}
+	for _, tolerance := range []float64{0, 0.01, 0.1, 1, 100} {
+		points := SimplifyTrack(track, tolerance)
+		if points[0] != track[0] || points[len(points)-1] != track[len(track)-1] {
+			t.Fatalf("tolerance %v: first and last points not kept", tolerance)
+		}
+		for i := 1; i < len(points); i++ {
+			if points[i].Time <= points[i-1].Time {
+				t.Fatalf("tolerance %v: time %v after %v", tolerance, points[i].Time, points[i-1].Time)
+			}
+		}
+		for _, point := range track {
+			if diff := math.Abs(TrackValue(points, point.Time) - point.Value); diff > tolerance+1e-9 {
+				t.Fatalf("tolerance %v: error %v at %v", tolerance, diff, point.Time)
+			}
+		}
+		if tolerance == 0 && len(points) != len(track) || tolerance == 100 && len(points) != 2 {
+			t.Errorf("tolerance %v: %d points", tolerance, len(points))
+		}
+	}
+}
+
+// delta 为 0 的帧会产生时间相同的点，跳变要保留，不能出现 NaN
+func TestSimplifyTrackDuplicateTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		track [][2]float64
+		want  [][2]float64
+	}{
+		{"step", [][2]float64{{0, 0}, {1, 0}, {1, 5}, {2, 5}}, [][2]float64{{0, 0}, {1, 0}, {1, 5}, {2, 5}}},
+		{"repeat", [][2]float64{{0, 0}, {1, 1}, {1, 1}, {1, 1}, {2, 2}}, [][2]float64{{0, 0}, {2, 2}}},
+		{"same time", [][2]float64{{1, 0}, {1, 3}, {1, 0}}, [][2]float64{{1, 0}, {1, 3}, {1, 0}}},
+		{"same time flat", [][2]float64{{1, 2}, {1, 2.05}, {1, 2}}, [][2]float64{{1, 2}, {1, 2}}},
+	}
+	for _, test := range tests {
+		track := make([]*Point, 0)
+		for _, item := range test.track {
+			track = append(track, &Point{Time: item[0], Value: item[1]})
+		}
+		points := SimplifyTrack(track, 0.1)
+		got := make([][2]float64, 0)
+		for _, point := range points {
+			if math.IsNaN(point.Value) {
+				t.Fatalf("%s: NaN", test.name)
+			}
+			got = append(got, [2]float64{point.Time, point.Value})
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// 没有变化的参数与部件不生成曲线，生成的曲线与录制的值误差在 Tolerance * 参数范围内
+func TestRecorder(t *testing.T) {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0), NewTestParameter("ParamB", 3)}, "PartA", "PartB")
+	recorder := NewRecorder(model, 0.01)
+	moc := model.Moc
+	values := make([]float64, 0)
+	for i := 0; i < 90; i++ {
+		value := 10 * math.Sin(float64(i)/10)
+		moc.ParameterValues[0] = float32(value)
+		moc.PartOpacities[1] = float32(i % 2)
+		values = append(values, float64(float32(value)))
+		recorder.Record(1.0 / 30)
+	}
+	motion := recorder.ToMotion()
+	if math.Abs(motion.Data.Meta.Duration-89.0/30) > 1e-9 {
+		t.Errorf("duration %v", motion.Data.Meta.Duration)
+	}
+	if len(motion.Curves) != 2 || motion.Curves[0].Data.Id != "ParamA" || motion.Curves[1].Data.Id != "PartB" {
+		t.Fatalf("curves %d", len(motion.Curves))
+	}
+	curve := motion.Curves[0]
+	if curve.Handle != 0 || len(curve.Segments) >= 89 {
+		t.Errorf("handle %d segments %d", curve.Handle, len(curve.Segments))
+	}
+	for i, value := range values {
+		got, ok := GetCurveValue(curve, recorder.ParameterTracks[0][i].Time, nil) // 累加的时间与 i/30 有舍入误差
+		if !ok || math.Abs(got-value) > 0.01*20+1e-6 {
+			t.Fatalf("frame %d: got %v want %v", i, got, value)
+		}
+	}
+	// 没有录制任何帧
+	if motion := NewRecorder(model, 0.01).ToMotion(); len(motion.Curves) != 0 || motion.Data.Meta.Duration != 0 {
+		t.Errorf("empty: curves %d duration %v", len(motion.Curves), motion.Data.Meta.Duration)
+	}
+}
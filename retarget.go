@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+)
+
+// Cubism 2 标准参数 Id 对应的 Cubism 3 Id
+var Cubism2Aliases = map[string]string{
+	"PARAM_ANGLE_X":       "ParamAngleX",
+	"PARAM_ANGLE_Y":       "ParamAngleY",
+	"PARAM_ANGLE_Z":       "ParamAngleZ",
+	"PARAM_EYE_L_OPEN":    "ParamEyeLOpen",
+	"PARAM_EYE_L_SMILE":   "ParamEyeLSmile",
+	"PARAM_EYE_R_OPEN":    "ParamEyeROpen",
+	"PARAM_EYE_R_SMILE":   "ParamEyeRSmile",
+	"PARAM_EYE_FORM":      "ParamEyeForm",
+	"PARAM_EYE_BALL_X":    "ParamEyeBallX",
+	"PARAM_EYE_BALL_Y":    "ParamEyeBallY",
+	"PARAM_EYE_BALL_FORM": "ParamEyeBallForm",
+	"PARAM_BROW_L_Y":      "ParamBrowLY",
+	"PARAM_BROW_R_Y":      "ParamBrowRY",
+	"PARAM_BROW_L_X":      "ParamBrowLX",
+	"PARAM_BROW_R_X":      "ParamBrowRX",
+	"PARAM_BROW_L_ANGLE":  "ParamBrowLAngle",
+	"PARAM_BROW_R_ANGLE":  "ParamBrowRAngle",
+	"PARAM_BROW_L_FORM":   "ParamBrowLForm",
+	"PARAM_BROW_R_FORM":   "ParamBrowRForm",
+	"PARAM_MOUTH_FORM":    "ParamMouthForm",
+	"PARAM_MOUTH_OPEN_Y":  "ParamMouthOpenY",
+	"PARAM_TERE":          "ParamCheek",
+	"PARAM_CHEEK":         "ParamCheek",
+	"PARAM_BODY_ANGLE_X":  "ParamBodyAngleX",
+	"PARAM_BODY_ANGLE_Y":  "ParamBodyAngleY",
+	"PARAM_BODY_ANGLE_Z":  "ParamBodyAngleZ",
+	"PARAM_BREATH":        "ParamBreath",
+	"PARAM_ARM_L_A":       "ParamArmLA",
+	"PARAM_ARM_R_A":       "ParamArmRA",
+	"PARAM_ARM_L_B":       "ParamArmLB",
+	"PARAM_ARM_R_B":       "ParamArmRB",
+	"PARAM_HAND_L":        "ParamHandL",
+	"PARAM_HAND_R":        "ParamHandR",
+	"PARAM_SHOULDER_Y":    "ParamShoulderY",
+	"PARAM_BUST_X":        "ParamBustX",
+	"PARAM_BUST_Y":        "ParamBustY",
+	"PARAM_HAIR_FRONT":    "ParamHairFront",
+	"PARAM_HAIR_SIDE":     "ParamHairSide",
+	"PARAM_HAIR_BACK":     "ParamHairBack",
+	"PARAM_HAIR_FLUFFY":   "ParamHairFluffy",
+	"PARAM_BASE_X":        "ParamBaseX",
+	"PARAM_BASE_Y":        "ParamBaseY",
+}
+
+// Cubism 3 Id 对应的 Cubism 2 Id，PARAM_TERE 与 PARAM_CHEEK 都对应 ParamCheek，按字母顺序依次尝试，结果不随 map 的遍历顺序变化
+var Cubism3Aliases = ReverseAliases(Cubism2Aliases)
+
+func ReverseAliases(aliases map[string]string) map[string][]string {
+	res := make(map[string][]string)
+	for alias, id := range aliases {
+		res[id] = append(res[id], alias)
+	}
+	for _, items := range res {
+		slices.Sort(items)
+	}
+	return res
+}
+
+// 把一个模型的动作用到另一个模型上，Id 按映射表转换，参数值从源范围线性映射到目标范围
+type Retarget struct {
+	Ids          map[string]string     // 自定义映射，优先于别名表
+	SourceParams map[string]*Parameter // 源模型的参数，没有时不映射范围
+}
+
+func NewRetarget(sourceParams []*Parameter, ids map[string]string) *Retarget {
+	res := &Retarget{Ids: ids, SourceParams: make(map[string]*Parameter)}
+	if res.Ids == nil {
+		res.Ids = make(map[string]string)
+	}
+	for _, param := range sourceParams {
+		res.SourceParams[param.Id] = param
+	}
+	return res
+}
+
+// 依次尝试自定义映射、原 Id、Cubism 2 -> 3 与 3 -> 2 的别名，目标中都没有时返回空
+func (r *Retarget) MapId(target string, id string, moc *Moc) string {
+	exist := func(id string) bool {
+		if target == TargetPartOpacity {
+			return moc.GetPartHandle(id) != InvalidHandle
+		}
+		return moc.GetParameterHandle(id) != InvalidHandle
+	}
+	if res, ok := r.Ids[id]; ok {
+		if exist(res) {
+			return res
+		}
+		return ""
+	}
+	if exist(id) {
+		return id
+	}
+	if res, ok := Cubism2Aliases[id]; ok && exist(res) {
+		return res
+	}
+	for _, alias := range Cubism3Aliases[id] {
+		if exist(alias) {
+			return alias
+		}
+	}
+	return ""
+}
+
+// 从源参数范围线性映射到目标参数范围，delta 为偏移量时只缩放
+func (r *Retarget) MapValue(id string, dst *Parameter, value float64, delta bool) float64 {
+	src, ok := r.SourceParams[id]
+	if !ok || dst == nil || src.Maximum <= src.Minimum {
+		return value
+	}
+	scale := float64(dst.Maximum-dst.Minimum) / float64(src.Maximum-src.Minimum)
+	if delta {
+		return value * scale
+	}
+	return float64(dst.Minimum) + (value-float64(src.Minimum))*scale
+}
+
+// 表情的 Add 是偏移量只缩放，Multiply 是倍数不用映射
+func (r *Retarget) RetargetExpression(expression *ExpressionData1, moc *Moc, params []*Parameter) *ExpressionData1 {
+	res := *expression
+	res.Parameters = make([]*ParameterData1, 0)
+	for _, item := range expression.Parameters {
+		id := r.MapId(TargetParameter, item.Id, moc)
+		if len(id) == 0 {
+			continue
+		}
+		dst := params[moc.GetParameterHandle(id)]
+		param := &ParameterData1{Id: id, Value: item.Value, Blend: item.Blend}
+		switch item.Blend {
+		case BlendMultiply:
+		case BlendOverwrite:
+			param.Value = r.MapValue(item.Id, dst, item.Value, false)
+		default:
+			param.Value = r.MapValue(item.Id, dst, item.Value, true)
+		}
+		res.Parameters = append(res.Parameters, param)
+	}
+	return &res
+}
+
+// 返回新的动作，源动作可能被缓存共用，不能直接修改；目标中没有的曲线直接丢弃
+func (r *Retarget) RetargetMotion(motion *Motion, moc *Moc, params []*Parameter) *Motion {
+	data := *motion.Data
+	data.Curves = nil
+	if motion.Data.Data != nil { // 声音相对源模型的目录，不能在目标模型中播放
+		ref := *motion.Data.Data
+		ref.Sound = ""
+		data.Data = &ref
+	}
+	curves := make([]*Curve, 0)
+	for _, curve := range motion.Curves {
+		curveData := *curve.Data
+		curveData.Segments = nil
+		var dst *Parameter
+		if curve.Data.Target != TargetModel {
+			curveData.Id = r.MapId(curve.Data.Target, curve.Data.Id, moc)
+			if len(curveData.Id) == 0 {
+				continue
+			}
+		}
+		if curve.Data.Target == TargetParameter {
+			dst = params[moc.GetParameterHandle(curveData.Id)]
+		}
+		segments := make([]*Segment, 0)
+		for _, segment := range curve.Segments {
+			points := make([]*Point, 0)
+			for _, point := range segment.Points {
+				points = append(points, &Point{Time: point.Time, Value: r.MapValue(curve.Data.Id, dst, point.Value, false)})
+			}
+			segments = append(segments, NewSegment(segment.Type, points...))
+		}
+		if len(segments) == 0 && len(curve.Data.Segments) >= 2 { // 只有起始点的曲线，写出 json 时需要
+			start := curve.Data.Segments
+			curveData.Segments = []float64{start[0], r.MapValue(curve.Data.Id, dst, start[1], false)}
+		}
+		data.Curves = append(data.Curves, &curveData)
+		temp := *curve
+		temp.Data = &curveData
+		temp.Segments = segments
+		curves = append(curves, &temp)
+	}
+	res := &Motion{Data: &data, Curves: curves}
+	BindMotion(res, moc)
+	return res
+}
+
+// 随机播放源模型中的一个动作，源模型只用来读取动作，不需要加入场景
+func (m *MotionManager) PlayMotionFrom(source *Model, name string, retarget *Retarget, mode LoopMode) {
+	motions := source.ModelData.FileReferences.Motions[name]
+	idx := rand.Intn(len(motions))
+	m.StartMotion(retarget.RetargetMotion(source.GetMotion(name, idx), m.Model.Moc, m.Model.Parameters), mode)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestMapId(t *testing.T) {
+	params := []*Parameter{NewTestParameter("ParamAngleX", 0), NewTestParameter("PARAM_TERE", 0),
+		NewTestParameter("PARAM_CHEEK", 0), NewTestParameter("PARAM_BREATH", 0), NewTestParameter("Custom", 0)}
+	moc := NewTestModel(params, "PartArmA").Moc
+	retarget := NewRetarget(nil, map[string]string{"ParamBrowLY": "Custom", "ParamBrowRY": "Missing"})
+	tests := []struct {
+		target string
+		id     string
+		want   string
+	}{
+		{TargetParameter, "ParamBrowLY", "Custom"},
+		{TargetParameter, "ParamBrowRY", ""}, // 自定义映射优先，目标中没有时不再尝试别名
+		{TargetParameter, "ParamAngleX", "ParamAngleX"},
+		{TargetParameter, "PARAM_ANGLE_X", "ParamAngleX"},
+		{TargetParameter, "ParamBreath", "PARAM_BREATH"},
+		{TargetParameter, "ParamCheek", "PARAM_CHEEK"}, // 两个别名都存在时按字母顺序
+		{TargetParameter, "ParamAngleY", ""},
+		{TargetPartOpacity, "PartArmA", "PartArmA"},
+		{TargetPartOpacity, "ParamAngleX", ""},
+	}
+	for i := 0; i < 20; i++ { // map 每次遍历的顺序不同，多试几次
+		for _, test := range tests {
+			if got := retarget.MapId(test.target, test.id, moc); got != test.want {
+				t.Fatalf("%s %s: got %q want %q", test.target, test.id, got, test.want)
+			}
+		}
+	}
+	moc = NewTestModel([]*Parameter{NewTestParameter("PARAM_TERE", 0)}).Moc
+	if got := retarget.MapId(TargetParameter, "ParamCheek", moc); got != "PARAM_TERE" {
+		t.Errorf("ParamCheek: got %q want PARAM_TERE", got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"math"
+	"slices"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+//go:embed mask.kage
+var shaderFS embed.FS
+
+// shader中使用的图片必须等大小，这里必须要先把图片绘制到另一个图片上
+// 模型是依次绘制的，同一个场景中的模型共用一份
+type MaskRenderer struct {
+	Shader *ebiten.Shader
+	Mask   *ebiten.Image
+	Src    *ebiten.Image
+}
+
+func NewMaskRenderer() *MaskRenderer {
+	return &MaskRenderer{Shader: OpenShader(shaderFS, "mask.kage")}
+}
+
+// 临时图片与屏幕等大，大小改变时才重新创建
+func (r *MaskRenderer) Resize(w int, h int) {
+	if r.Mask != nil && r.Mask.Bounds().Dx() == w && r.Mask.Bounds().Dy() == h {
+		return
+	}
+	r.Mask = ebiten.NewImage(w, h)
+	r.Src = ebiten.NewImage(w, h)
+}
+
+// 画布等比缩放到 width*height 以内
+func NewTransform(model *Model, width float32, height float32) *Transform {
+	size, origin, _ := GetCanvasInfo(model.Moc.Model)
+	scale := min(width/size.X, height/size.Y)
+	return &Transform{
+		Size:   Vector2{X: size.X * scale, Y: size.Y * scale},
+		Origin: Vector2{X: origin.X * scale, Y: origin.Y * scale},
+		Scale:  scale,
+	}
+}
+
+type Instance struct {
+	Path          string
+	MotionManager *MotionManager
+	Transform     *Transform
+	Z             int     // 越大越靠前绘制
+	UpdateRate    float64 // 每秒更新次数，<=0 时每帧都更新
+	UpdateTimer   float64 // 距离上次更新累计的时间
+}
+
+func (i *Instance) Update(delta float64) {
+	if i.UpdateRate <= 0 {
+		i.MotionManager.Update(delta)
+		return
+	}
+	i.UpdateTimer += delta
+	interval := 1 / i.UpdateRate
+	if i.UpdateTimer >= interval { // 低频更新时一次性推进整数个间隔，剩余的时间留到下次，更新的节奏不会漂移
+		steps := math.Floor(i.UpdateTimer / interval)
+		i.MotionManager.Update(steps * interval)
+		i.UpdateTimer -= steps * interval
+	}
+}
+
+// 场景中可以放置多个模型，同一个 model3.json 的多个实例共用 moc、纹理与动作数据
+type Scene struct {
+	Models    map[string]*Model
+	Instances []*Instance // 按 Z 排序
+	Renderer  *MaskRenderer
+	Pending   []*PendingModel // 后台加载中的模型
+}
+
+type PendingModel struct {
+	Path      string
+	Loader    *ModelLoader
+	Transform *Transform
+	Z         int
+	OnLoad    func(instance *Instance, err error) // 在 Update 中（主线程）回调
+}
+
+func NewScene() *Scene {
+	return &Scene{Models: make(map[string]*Model), Renderer: NewMaskRenderer()}
+}
+
+func (s *Scene) AddModel(path string, transform *Transform, z int) *Instance {
+	model, ok := s.Models[path]
+	if ok {
+		model = model.NewInstance()
+	} else {
+		model = LoadModel(path)
+		s.Models[path] = model
+	}
+	return s.AddInstance(path, model, transform, z)
+}
+
+// 在后台读取并解码模型文件，不阻塞窗口，加载完成后才加入场景
+// 已经加载过的模型直接复用并立即回调，ctx 取消时 onLoad 收到对应的错误
+func (s *Scene) AddModelAsync(ctx context.Context, path string, transform *Transform, z int, onLoad func(instance *Instance, err error)) *PendingModel {
+	if model, ok := s.Models[path]; ok {
+		instance := s.AddInstance(path, model.NewInstance(), transform, z)
+		if onLoad != nil {
+			onLoad(instance, nil)
+		}
+		return nil
+	}
+	fsys, name := OpenModelFS(path)
+	pending := &PendingModel{Path: path, Loader: LoadModelAsync(ctx, fsys, name), Transform: transform, Z: z, OnLoad: onLoad}
+	s.Pending = append(s.Pending, pending)
+	return pending
+}
+
+func (s *Scene) AddInstance(path string, model *Model, transform *Transform, z int) *Instance {
+	instance := &Instance{
+		Path:          path,
+		MotionManager: NewMotionManager(model, transform, s.Renderer),
+		Transform:     transform,
+		Z:             z,
+	}
+	s.Instances = append(s.Instances, instance)
+	s.SortInstances()
+	return instance
+}
+
+// 移除并释放实例，纹理等共用资源在最后一个实例移除时释放
+func (s *Scene) RemoveInstance(instance *Instance) {
+	s.Instances = slices.DeleteFunc(s.Instances, func(item *Instance) bool {
+		return item == instance
+	})
+	model := instance.MotionManager.Model
+	instance.MotionManager.Release()
+	if s.Models[instance.Path] != model {
+		return
+	}
+	// 缓存的模型被释放了，换成同一路径下还存活的实例
+	delete(s.Models, instance.Path)
+	for _, item := range s.Instances {
+		if item.Path == instance.Path {
+			s.Models[item.Path] = item.MotionManager.Model
+			break
+		}
+	}
+}
+
+func (s *Scene) Release() {
+	for len(s.Instances) > 0 {
+		s.RemoveInstance(s.Instances[0])
+	}
+}
+
+func (s *Scene) SetZ(instance *Instance, z int) {
+	instance.Z = z
+	s.SortInstances()
+}
+
+func (s *Scene) SortInstances() {
+	slices.SortStableFunc(s.Instances, func(a, b *Instance) int {
+		return a.Z - b.Z
+	})
+}
+
+func (s *Scene) Update(delta float64) {
+	s.UpdatePending()
+	for _, instance := range s.Instances {
+		instance.Update(delta)
+	}
+}
+
+func (s *Scene) UpdatePending() {
+	s.Pending = slices.DeleteFunc(s.Pending, func(pending *PendingModel) bool {
+		model, err := pending.Loader.Poll()
+		if model == nil && err == nil {
+			return false
+		}
+		var instance *Instance
+		if err == nil {
+			if old, ok := s.Models[pending.Path]; ok { // 期间同一路径已经加载好了，用缓存的
+				model.Release()
+				model = old.NewInstance()
+			} else {
+				s.Models[pending.Path] = model
+			}
+			instance = s.AddInstance(pending.Path, model, pending.Transform, pending.Z)
+		}
+		if pending.OnLoad != nil {
+			pending.OnLoad(instance, err)
+		}
+		return true
+	})
+}
+
+func (s *Scene) Draw(screen *ebiten.Image) {
+	for _, instance := range s.Instances {
+		instance.MotionManager.Draw(screen)
+	}
+}
@@ -0,0 +1,117 @@
+//go:build display
+
+package main
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func NewTestInstance(tb testing.TB) (*Scene, *Instance) {
+	scene := NewScene()
+	model := LoadTestModel(tb)
+	scene.Models[TestModelPath] = model
+	instance := scene.AddInstance(TestModelPath, model, NewTransform(model, 1440, 810), 0)
+	instance.MotionManager.PlayMotion(PreloadMotionGroup, LoopForever)
+	return scene, instance
+}
+
+// 第一帧会创建游标等缓存，之后的更新不应该再分配内存
+func TestUpdateAllocs(t *testing.T) {
+	scene, instance := NewTestInstance(t)
+	defer scene.Release()
+	instance.MotionManager.Update(1.0 / 60)
+	allocs := testing.AllocsPerRun(100, func() {
+		instance.MotionManager.Update(1.0 / 60)
+	})
+	if allocs > 0 {
+		t.Errorf("update allocs %v per frame", allocs)
+	}
+}
+
+// 完整的一帧更新与绘制，go test -tags display -bench UpdateDraw -run ^$ 查看 allocs/op
+func BenchmarkUpdateDraw(b *testing.B) {
+	scene, instance := NewTestInstance(b)
+	defer scene.Release()
+	screen := ebiten.NewImage(int(instance.Transform.Size.X), int(instance.Transform.Size.Y))
+	defer screen.Deallocate()
+	scene.Update(1.0 / 60)
+	scene.Draw(screen)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scene.Update(1.0 / 60)
+		scene.Draw(screen)
+	}
+}
+
+// 反复加载与卸载，共用的 moc 在最后一个实例释放后引用数归零，内存不会持续增长
+func TestReleaseLoop(t *testing.T) {
+	LoadTestModel(t).Release()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 20; i++ {
+		scene := NewScene()
+		instance1 := scene.AddModel(TestModelPath, &Transform{}, 0)
+		instance2 := scene.AddModel(TestModelPath, &Transform{}, 1)
+		moc1, moc2 := instance1.MotionManager.Model.Moc, instance2.MotionManager.Model.Moc
+		if moc1.Refs != moc2.Refs || *moc1.Refs != 2 {
+			t.Fatalf("refs %d, shared %v", *moc1.Refs, moc1.Refs == moc2.Refs)
+		}
+		model1, model2 := instance1.MotionManager.Model, instance2.MotionManager.Model
+		images := model2.Images
+		scene.RemoveInstance(instance1)
+		if *moc2.Refs != 1 || scene.Models[TestModelPath] != model2 {
+			t.Fatalf("after first release: refs %d", *moc2.Refs)
+		}
+		if model1.Images != nil || len(images) == 0 { // 纹理还在被第二个实例使用
+			t.Fatalf("after first release: images %d shared %d", len(model1.Images), len(images))
+		}
+		scene.Release()
+		if *moc2.Refs != 0 || !moc1.Released || !moc2.Released || len(scene.Models) != 0 || len(scene.Instances) != 0 {
+			t.Fatalf("after release: refs %d models %d instances %d", *moc2.Refs, len(scene.Models), len(scene.Instances))
+		}
+		if model2.Images != nil || len(images) != 0 {
+			t.Fatalf("after release: images %d shared %d", len(model2.Images), len(images))
+		}
+		for _, drawable := range append(model1.Drawables, model2.Drawables...) {
+			if drawable.Image != nil {
+				t.Fatalf("drawable %s still holds its image", drawable.Id)
+			}
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if growth := int64(after.HeapAlloc) - int64(before.HeapAlloc); growth > 16<<20 {
+		t.Errorf("heap grew %d bytes after unloading", growth)
+	}
+}
+
+// 释放后继续使用会直接报错，而不是读写已经释放的缓存区
+func TestUseAfterRelease(t *testing.T) {
+	scene, instance := NewTestInstance(t)
+	scene.Release()
+	if err := Try(func() { instance.MotionManager.Update(1.0 / 60) }); err == nil {
+		t.Error("update after release should fail")
+	}
+}
+
+// 低频更新时每次推进整数个间隔，剩余的时间留到下次，总时间不会丢失
+func TestInstanceUpdateRate(t *testing.T) {
+	scene, instance := NewTestInstance(t)
+	defer scene.Release()
+	instance.UpdateRate = 10
+	manager := instance.MotionManager
+	for i := 0; i < 10; i++ {
+		instance.Update(0.07)
+		steps := manager.Elapsed / 0.1
+		if math.Abs(steps-math.Round(steps)) > 1e-9 || instance.UpdateTimer >= 0.1 ||
+			math.Abs(manager.Elapsed+instance.UpdateTimer-0.07*float64(i+1)) > 1e-9 {
+			t.Fatalf("frame %d: elapsed %v timer %v", i, manager.Elapsed, instance.UpdateTimer)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 模型的完整状态，参数与部件按 Id 保存，模型更新后也能尽量恢复
+// 物理暂未实现，没有粒子状态
+type Snapshot struct {
+	Parameters    map[string]float32  `json:"Parameters"` // 动作的结果，表情与覆盖在恢复后重新计算
+	PartOpacities map[string]float32  `json:"PartOpacities"`
+	Motion        *MotionSnapshot     `json:"Motion,omitempty"`
+	Expression    *ExpressionSnapshot `json:"Expression,omitempty"`
+	Overrides     []*Override         `json:"Overrides,omitempty"`
+}
+
+type MotionSnapshot struct {
+	Group        string  `json:"Group"`
+	Index        int     `json:"Index"`
+	Timer        float64 `json:"Timer"`
+	Loop         bool    `json:"Loop"`
+	LoopFadeTime float64 `json:"LoopFadeTime"`
+	Speed        float64 `json:"Speed"`
+	Paused       bool    `json:"Paused"`
+	RangeStart   float64 `json:"RangeStart"`
+	RangeEnd     float64 `json:"RangeEnd"`
+	PingPong     bool    `json:"PingPong"`
+	Elapsed      float64 `json:"Elapsed"`
+}
+
+type ExpressionSnapshot struct {
+	Name  string  `json:"Name"`
+	Timer float64 `json:"Timer"`
+}
+
+func (m *MotionManager) Snapshot() *Snapshot {
+	moc := m.Model.Moc
+	res := &Snapshot{Parameters: make(map[string]float32), PartOpacities: make(map[string]float32)}
+	for i, param := range m.Model.Parameters {
+		res.Parameters[param.Id] = moc.ParameterValues[i]
+	}
+	for i := len(m.LayerHandles) - 1; i >= 0; i-- { // 去掉表情与覆盖的修改
+		res.Parameters[m.Model.Parameters[m.LayerHandles[i]].Id] = m.LayerValues[i]
+	}
+	for i, part := range m.Model.Parts {
+		res.PartOpacities[part.Id] = moc.PartOpacities[i]
+	}
+	if m.Motion != nil && len(m.MotionGroup) > 0 { // 其他来源的动作没法恢复
+		res.Motion = &MotionSnapshot{Group: m.MotionGroup, Index: m.MotionIndex, Timer: m.Timer, Loop: m.Loop,
+			LoopFadeTime: m.LoopFadeTime, Speed: m.Speed, Paused: m.Paused, RangeStart: m.RangeStart,
+			RangeEnd: m.RangeEnd, PingPong: m.PingPong, Elapsed: m.Elapsed}
+	}
+	if m.Expression != nil {
+		res.Expression = &ExpressionSnapshot{Name: m.Expression.Name, Timer: m.ExpressionTimer}
+	}
+	for _, item := range m.Overrides {
+		temp := *item
+		res.Overrides = append(res.Overrides, &temp)
+	}
+	return res
+}
+
+// 先检查快照中的动作、表情与覆盖都存在，有问题时返回错误并保持原来的状态，不会只恢复一半
+// 模型中已经不存在的参数与部件直接忽略
+func (m *MotionManager) Restore(snapshot *Snapshot) error {
+	moc := m.Model.Moc
+	var motion *Motion
+	if item := snapshot.Motion; item != nil {
+		if item.Index < 0 || item.Index >= len(m.Model.ModelData.FileReferences.Motions[item.Group]) {
+			return fmt.Errorf("motion %s %d not found", item.Group, item.Index)
+		}
+		if err := Try(func() { motion = m.Model.GetMotion(item.Group, item.Index) }); err != nil {
+			return err
+		}
+	}
+	var expression *ExpressionData1
+	if item := snapshot.Expression; item != nil {
+		for _, temp := range m.Model.ExpressionDatas {
+			if temp.Name == item.Name {
+				expression = temp
+			}
+		}
+		if expression == nil {
+			return fmt.Errorf("expression %s not found", item.Name)
+		}
+	}
+	for _, item := range snapshot.Overrides {
+		if moc.GetParameterHandle(item.Id) == InvalidHandle {
+			return fmt.Errorf("override parameter %s not found", item.Id)
+		}
+	}
+	m.LayerHandles, m.LayerValues = m.LayerHandles[:0], m.LayerValues[:0] // 保存的是没有叠加表情与覆盖的值
+	for id, value := range snapshot.Parameters {
+		if handle := moc.GetParameterHandle(id); handle != InvalidHandle {
+			moc.SetParameterValue(handle, value)
+		}
+	}
+	for id, value := range snapshot.PartOpacities {
+		if handle := moc.GetPartHandle(id); handle != InvalidHandle {
+			moc.SetPartOpacity(handle, value)
+		}
+	}
+	m.StopMotion()
+	if item := snapshot.Motion; item != nil {
+		m.Motion, m.Cursors = motion, nil // 不用 StartMotion，声音没法从中间恢复
+		m.MotionGroup, m.MotionIndex = item.Group, item.Index
+		m.Loop, m.LoopFadeTime, m.Speed, m.Paused = item.Loop, item.LoopFadeTime, item.Speed, item.Paused
+		m.PingPong, m.Elapsed = item.PingPong, item.Elapsed
+		m.SetRange(item.RangeStart, item.RangeEnd)
+		m.Seek(item.Timer)
+	}
+	m.StopExpression()
+	if item := snapshot.Expression; item != nil {
+		m.Expression, m.ExpressionTimer = expression, item.Timer
+	}
+	m.Overrides = nil
+	for _, item := range snapshot.Overrides { // 前面已经检查过 id
+		_, err := m.SetOverride(item.Source, item.Id, item.Value, item.Weight, item.Priority, item.Timeout)
+		HandleErr(err)
+	}
+	return nil
+}
+
+func (m *MotionManager) SaveSnapshot(file string) {
+	bs, err := json.MarshalIndent(m.Snapshot(), "", "\t")
+	HandleErr(err)
+	HandleErr(os.WriteFile(file, bs, 0644))
+}
+
+// 快照损坏时返回错误，由调用方决定是否忽略，不会因为一个坏文件每次启动都崩溃
+func (m *MotionManager) LoadSnapshot(file string) error {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(bs, snapshot); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if err = m.Restore(snapshot); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
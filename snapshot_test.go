@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func NewSnapshotTestManager() *MotionManager {
+	model := NewTestModel([]*Parameter{NewTestParameter("ParamA", 0), NewTestParameter("ParamB", 0),
+		NewTestParameter("ParamC", 1)}, "PartA", "PartB")
+	AddTestMotion(model, "Idle", NewTestMotionData(1, true, map[string][2]float64{"ParamA": {0, 1}}))
+	AddTestMotion(model, "Tap", NewTestMotionData(2, false, map[string][2]float64{"ParamA": {-2, 2}}))
+	AddTestExpression(model, "smile", &ParameterData1{Id: "ParamC", Value: 2, Blend: BlendMultiply})
+	return NewTestManager(model)
+}
+
+func MarshalSnapshot(t *testing.T, snapshot *Snapshot) string {
+	bs, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+// 保存为 json 再恢复到新的模型上，之后的每一帧都与原来的模型一致
+func TestSnapshotRoundTrip(t *testing.T) {
+	manager := NewSnapshotTestManager()
+	manager.PlayMotion("Tap", LoopForever)
+	manager.SetRange(0.5, 1.5)
+	manager.PingPong, manager.LoopFadeTime = true, 0.2
+	manager.SetSpeed(0.5)
+	manager.PlayExpression("smile")
+	manager.SetOverride("app", "ParamB", 3, 0.5, 1, 10)
+	manager.SetOverride("look", "ParamA", 1, 0.25, 0, 0)
+	manager.Model.Moc.SetPartOpacity(1, 0.3)
+	for i := 0; i < 100; i++ {
+		manager.UpdateParameters(1.0 / 30)
+	}
+	bs := MarshalSnapshot(t, manager.Snapshot())
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal([]byte(bs), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewSnapshotTestManager()
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if got := MarshalSnapshot(t, restored.Snapshot()); got != bs {
+		t.Errorf("snapshot changed:\n%s\n%s", got, bs)
+	}
+	if restored.Timer != manager.Timer || restored.RangeStart != 0.5 || restored.RangeEnd != 1.5 || restored.Speed != manager.Speed {
+		t.Errorf("motion: timer %v range %v %v speed %v", restored.Timer, restored.RangeStart, restored.RangeEnd, restored.Speed)
+	}
+	if len(restored.Overrides) != 2 || restored.Overrides[1].Id != "ParamB" || restored.Overrides[1].Timeout != manager.Overrides[1].Timeout {
+		t.Errorf("overrides %+v", restored.Overrides)
+	}
+	if restored.Model.Moc.PartOpacities[1] != 0.3 {
+		t.Errorf("part opacities %v", restored.Model.Moc.PartOpacities)
+	}
+	for i := 0; i < 100; i++ {
+		manager.UpdateParameters(1.0 / 30)
+		restored.UpdateParameters(1.0 / 30)
+		if got, want := restored.Model.Moc.ParameterValues, manager.Model.Moc.ParameterValues; !reflect.DeepEqual(got, want) {
+			t.Fatalf("frame %d: values %v want %v", i, got, want)
+		}
+	}
+}
+
+// 快照中的动作、表情或覆盖不存在时返回错误，模型保持原来的状态
+func TestRestoreInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(snapshot *Snapshot)
+	}{
+		{"motion group", func(snapshot *Snapshot) { snapshot.Motion.Group = "Missing" }},
+		{"motion index", func(snapshot *Snapshot) { snapshot.Motion.Index = 1 }},
+		{"negative index", func(snapshot *Snapshot) { snapshot.Motion.Index = -1 }},
+		{"expression", func(snapshot *Snapshot) { snapshot.Expression = &ExpressionSnapshot{Name: "angry"} }},
+		{"override", func(snapshot *Snapshot) {
+			snapshot.Overrides = append(snapshot.Overrides, &Override{Source: "app", Id: "ParamX", Weight: 1})
+		}},
+	}
+	for _, test := range tests {
+		manager := NewSnapshotTestManager()
+		manager.PlayMotion("Tap", LoopOnce)
+		manager.UpdateParameters(0.5)
+		snapshot := manager.Snapshot()
+		snapshot.Parameters["ParamB"] = 5
+		test.modify(snapshot)
+		manager.PlayMotion("Idle", LoopForever)
+		manager.UpdateParameters(0.25)
+		want := MarshalSnapshot(t, manager.Snapshot())
+		if err := manager.Restore(snapshot); err == nil {
+			t.Errorf("%s: restore should fail", test.name)
+		}
+		if got := MarshalSnapshot(t, manager.Snapshot()); got != want {
+			t.Errorf("%s: state changed:\n%s\n%s", test.name, got, want)
+		}
+	}
+}
+
+// 动作文件损坏时同样返回错误
+func TestRestoreCorruptMotion(t *testing.T) {
+	manager := NewSnapshotTestManager()
+	manager.PlayMotion("Tap", LoopOnce)
+	snapshot := manager.Snapshot()
+	restored := NewSnapshotTestManager()
+	ref := restored.Model.ModelData.FileReferences.Motions["Tap"][0]
+	restored.Model.FS.(fstest.MapFS)[ref.File].Data = []byte("{")
+	if err := restored.Restore(snapshot); err == nil || restored.Motion != nil {
+		t.Errorf("restore corrupt motion: %v", err)
+	}
+}
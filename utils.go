@@ -5,10 +5,15 @@
 package main
 
 import (
+	"archive/zip"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"unsafe"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -21,14 +26,105 @@ func HandleErr(err error) {
 	}
 }
 
-func ReadFile(path string) []byte {
-	bs, err := os.ReadFile(path)
+// 把 panic 转为 error，用于不能让整个程序崩溃的地方，例如热重载
+func Try(fn func()) (err error) {
+	defer func() {
+		if res := recover(); res != nil {
+			err = fmt.Errorf("%v", res)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func ReadFile(fsys fs.FS, path string) []byte {
+	bs, err := fs.ReadFile(fsys, path)
 	HandleErr(err)
 	return bs
 }
 
-func UnmarshalFile(path string, dst any) {
-	bs := ReadFile(path)
+// 普通路径返回 model3.json 与所有引用的文件共同的上级目录的 os.DirFS
+// ../shared/tex.png 这种引用上级目录的资源也能找到，但访问不到这个目录以外的文件
+// .zip 返回压缩包本身，并在其中查找 model3.json，引用不能超出压缩包
+func OpenModelFS(path string) (fs.FS, string) {
+	if strings.ToLower(filepath.Ext(path)) == ".zip" {
+		reader, err := zip.OpenReader(path)
+		HandleErr(err)
+		return reader, FindModelFile(reader)
+	}
+	path, err := filepath.Abs(path)
+	HandleErr(err)
+	dir := filepath.Dir(path)
+	root := dir
+	for _, file := range GetReferencedFiles(path) {
+		root = CommonDir(root, filepath.Join(dir, filepath.FromSlash(file)))
+	}
+	name, err := filepath.Rel(root, path)
+	HandleErr(err)
+	return os.DirFS(root), filepath.ToSlash(name)
+}
+
+// model3.json 中引用的所有文件，相对 model3.json 所在的目录，读取失败时返回空，错误在加载时报告
+func GetReferencedFiles(path string) []string {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	modelData := &ModelData{}
+	if json.Unmarshal(bs, modelData) != nil || modelData.FileReferences == nil {
+		return nil
+	}
+	ref := modelData.FileReferences
+	res := []string{ref.Moc, ref.Physics, ref.Pose, ref.DisplayInfo, ref.UserData}
+	res = append(res, ref.Textures...)
+	for _, item := range ref.Expressions {
+		res = append(res, item.File)
+	}
+	for _, motions := range ref.Motions {
+		for _, motion := range motions {
+			res = append(res, motion.File, motion.Sound)
+		}
+	}
+	return slices.DeleteFunc(res, func(file string) bool {
+		return len(file) == 0
+	})
+}
+
+// 包含 dir 与 file 的最近的目录
+func CommonDir(dir string, file string) string {
+	for {
+		rel, err := filepath.Rel(dir, file)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir { // 已经到根目录，例如 Windows 上的不同盘符
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// 找到第一个 model3.json，很多模型包解压后会多一层目录
+func FindModelFile(fsys fs.FS) string {
+	res := ""
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.HasSuffix(path, ".model3.json") {
+			res = path
+			return fs.SkipAll
+		}
+		return nil
+	})
+	HandleErr(err)
+	Assert(len(res) > 0, "model3.json not found")
+	return res
+}
+
+func UnmarshalFile(fsys fs.FS, path string, dst any) {
+	bs := ReadFile(fsys, path)
 	err := json.Unmarshal(bs, dst)
 	HandleErr(err)
 }
@@ -65,7 +161,25 @@ func PtrToStr(ptr unsafe.Pointer) string {
 	panic("invalid ptr to str")
 }
 
-func ToMotion(data *MotionData1) *Motion {
+func PtrToStrs(ptr unsafe.Pointer, count int32) []string {
+	res := make([]string, 0)
+	for i := int32(0); i < count; i++ {
+		// 每个指针占用 8 byte
+		item := *(**byte)(unsafe.Pointer(uintptr(ptr) + uintptr(i*8))) // 来回转换主要是写入类型信息
+		res = append(res, PtrToStr(unsafe.Pointer(item)))
+	}
+	return res
+}
+
+// 曲线在转换时就绑定到参数或部件的索引上，每帧不需要再查找 id
+func ToMotion(data *MotionData1, moc *Moc) *Motion {
+	motion := ConvertMotion(data)
+	BindMotion(motion, moc)
+	return motion
+}
+
+// 只转换曲线，不依赖 moc，Handle 都是 InvalidHandle
+func ConvertMotion(data *MotionData1) *Motion {
 	curves := make([]*Curve, 0) // 暂时没有管音乐
 	for _, item := range data.Curves {
 		lastPoint := &Point{
@@ -78,54 +192,30 @@ func ToMotion(data *MotionData1) *Motion {
 			type0 := item.Segments[i]
 			i++
 			switch type0 {
-			case CurveLinear:
+			case CurveLinear, CurveStepped, CurveInverseStepped: // 阶梯也保留两个点，反向阶梯取的是后一个点的值
 				nextPoint := &Point{Time: item.Segments[i], Value: item.Segments[i+1]}
-				segments = append(segments, &Segment{
-					Points: []*Point{lastPoint, nextPoint},
-					Type:   CurveLinear,
-				})
+				segments = append(segments, NewSegment(int(type0), lastPoint, nextPoint))
 				lastPoint = nextPoint
 				i += 2
 			case CurveBezier:
 				nextPoint := &Point{Time: item.Segments[i+4], Value: item.Segments[i+5]}
-				segments = append(segments, &Segment{
-					Points: []*Point{
-						lastPoint,
-						{Time: item.Segments[i], Value: item.Segments[i+1]},
-						{Time: item.Segments[i+2], Value: item.Segments[i+3]},
-						nextPoint,
-					},
-					Type: CurveBezier,
-				})
+				segments = append(segments, NewSegment(CurveBezier, lastPoint,
+					&Point{Time: item.Segments[i], Value: item.Segments[i+1]},
+					&Point{Time: item.Segments[i+2], Value: item.Segments[i+3]},
+					nextPoint))
 				lastPoint = nextPoint
 				i += 6
-			case CurveStepped:
-				nextPoint := &Point{Time: item.Segments[i], Value: item.Segments[i+1]}
-				segments = append(segments, &Segment{
-					Points: []*Point{lastPoint},
-					Type:   CurveStepped,
-					Value:  nextPoint.Time,
-				})
-				lastPoint = nextPoint
-				i += 2
-			case CurveInverseStepped:
-				nextPoint := &Point{Time: item.Segments[i], Value: item.Segments[i+1]}
-				segments = append(segments, &Segment{
-					Points: []*Point{lastPoint},
-					Type:   CurveInverseStepped,
-					Value:  lastPoint.Time,
-				})
-				lastPoint = nextPoint
-				i += 2
 			default:
 				panic(fmt.Sprintf("invalid type0: %v", type0))
 			}
 		}
 		curves = append(curves, &Curve{
-			Data:        item,
-			FadeInTime:  ElemOrDef(item.FadeInTime, -1),
-			FadeOutTime: ElemOrDef(item.FadeOutTime, -1),
-			Segments:    segments,
+			Data:              item,
+			Handle:            InvalidHandle,
+			BeziersRestricted: data.Meta.AreBeziersRestricted,
+			FadeInTime:        ElemOrDef(item.FadeInTime, -1),
+			FadeOutTime:       ElemOrDef(item.FadeOutTime, -1),
+			Segments:          segments,
 		})
 	}
 	return &Motion{
@@ -134,6 +224,18 @@ func ToMotion(data *MotionData1) *Motion {
 	}
 }
 
+// 根据 moc 查找曲线对应的参数或部件
+func BindMotion(motion *Motion, moc *Moc) {
+	for _, curve := range motion.Curves {
+		switch curve.Data.Target {
+		case TargetParameter:
+			curve.Handle = int32(moc.GetParameterHandle(curve.Data.Id))
+		case TargetPartOpacity:
+			curve.Handle = int32(moc.GetPartHandle(curve.Data.Id))
+		}
+	}
+}
+
 func ElemOrDef[T any](ptr *T, def T) T {
 	if ptr == nil {
 		return def
@@ -141,18 +243,29 @@ func ElemOrDef[T any](ptr *T, def T) T {
 	return *ptr
 }
 
-func GetFade(motion *Motion, timer float64) (float64, float64) {
+// elapsed 为开始播放后经过的时间，remaining 为距离结束的时间
+func GetFade(motion *Motion, elapsed float64, remaining float64) (float64, float64) {
+	fadeInTime, fadeOutTime := GetFadeTime(motion.Data)
 	fadeIn := 1.0 // 没有渐入渐出时间就取立即值
-	if motion.Data.Data.FadeInTime > 0 {
-		fadeIn = GetEasingSine(timer / motion.Data.Data.FadeInTime)
+	if fadeInTime > 0 {
+		fadeIn = GetEasingSine(elapsed / fadeInTime)
 	}
 	fadeOut := 1.0
-	if motion.Data.Data.FadeOutTime > 0 && motion.Data.Meta.Duration > 0 {
-		fadeOut = GetEasingSine(timer / motion.Data.Data.FadeOutTime)
+	if fadeOutTime > 0 {
+		fadeOut = GetEasingSine(remaining / fadeOutTime)
 	}
 	return fadeIn, fadeOut
 }
 
+// model3.json 中设置的优先，其次是动作 Meta 中的，都没有时为 0
+func GetFadeTime(data *MotionData1) (float64, float64) {
+	fadeInTime, fadeOutTime := ElemOrDef(data.Meta.FadeInTime, 0), ElemOrDef(data.Meta.FadeOutTime, 0)
+	if ref := data.Data; ref != nil { // 录制生成的动作不在 model3.json 中
+		fadeInTime, fadeOutTime = ElemOrDef(ref.FadeInTime, fadeInTime), ElemOrDef(ref.FadeOutTime, fadeOutTime)
+	}
+	return fadeInTime, fadeOutTime
+}
+
 func GetEasingSine(rate float64) float64 {
 	if rate < 0.0 {
 		return 0.0
@@ -163,30 +276,53 @@ func GetEasingSine(rate float64) float64 {
 	return 0.5 - 0.5*math.Cos(rate*math.Pi)
 }
 
-func GetRightSegments(segments []*Segment, timer float64) *Segment {
-	for _, segment := range segments {
-		switch segment.Type {
-		case CurveLinear:
-			if segment.Points[0].Time <= timer && segment.Points[1].Time >= timer {
-				return segment
-			}
-		case CurveBezier:
-			if segment.Points[0].Time <= timer && segment.Points[3].Time >= timer {
-				return segment
-			}
-		case CurveStepped:
-			if segment.Points[0].Time <= timer && segment.Value >= timer {
-				return segment
-			}
-		case CurveInverseStepped:
-			if segment.Value <= timer && segment.Points[0].Time >= timer {
-				return segment
-			}
-		default:
-			panic(fmt.Sprintf("invalid type0: %v", segment.Type))
+// 每个曲线控制一个部分，一个曲线分为多段，获取当前时间对应的段再求值
+// cursor 为上次找到的段，可以为 nil
+func GetCurveValue(curve *Curve, timer float64, cursor *int) (float64, bool) {
+	temp := 0
+	if cursor == nil {
+		cursor = &temp
+	}
+	*cursor = FindSegment(curve.Segments, timer, *cursor)
+	if *cursor < 0 {
+		*cursor = 0
+		return 0, false
+	}
+	segment := curve.Segments[*cursor]
+	if segment.Type == CurveBezier && !curve.BeziersRestricted {
+		return GetBezierCardanoValue(segment, timer), true
+	}
+	return GetSegmentValue(segment, timer), true
+}
+
+// 每段为 [Start, End)，最后一段包含 End，找不到时返回 -1
+// 正常播放时只会停留在当前段或移动到相邻段，先检查这几个，跳转时再二分查找
+func FindSegment(segments []*Segment, timer float64, cursor int) int {
+	contains := func(idx int) bool {
+		if idx < 0 || idx >= len(segments) {
+			return false
 		}
+		segment := segments[idx]
+		return segment.Start <= timer && (timer < segment.End || (idx == len(segments)-1 && timer == segment.End))
 	}
-	return nil
+	for _, idx := range []int{cursor, cursor + 1, cursor - 1} {
+		if contains(idx) {
+			return idx
+		}
+	}
+	idx, _ := slices.BinarySearchFunc(segments, timer, func(segment *Segment, timer float64) int {
+		if segment.End <= timer {
+			return -1
+		}
+		return 1
+	})
+	if contains(idx) {
+		return idx
+	}
+	if contains(idx - 1) { // 正好是最后一段的结尾
+		return idx - 1
+	}
+	return -1
 }
 
 func GetSegmentValue(segment *Segment, timer float64) float64 {
@@ -203,13 +339,94 @@ func GetSegmentValue(segment *Segment, timer float64) float64 {
 		p02 := LerpPoint(p01, p12, rate)
 		p13 := LerpPoint(p12, p23, rate)
 		return LerpPoint(p02, p13, rate).Value
-	case CurveStepped, CurveInverseStepped:
+	case CurveStepped:
 		return segment.Points[0].Value
+	case CurveInverseStepped:
+		return segment.Points[1].Value
 	default:
 		panic(fmt.Sprintf("invalid type0: %v", segment.Type))
 	}
 }
 
+// 控制点的时间不受限制时，时间不再与 t 线性对应，需要先从时间的三次方程解出 t
+func GetBezierCardanoValue(segment *Segment, timer float64) float64 {
+	p0, p1, p2, p3 := segment.Points[0], segment.Points[1], segment.Points[2], segment.Points[3]
+	a := p3.Time - 3*p2.Time + 3*p1.Time - p0.Time
+	b := 3*p2.Time - 6*p1.Time + 3*p0.Time
+	c := 3*p1.Time - 3*p0.Time
+	d := p0.Time - timer
+	rate := SolveCardano(a, b, c, d)
+	p01 := LerpPoint(p0, p1, rate)
+	p12 := LerpPoint(p1, p2, rate)
+	p23 := LerpPoint(p2, p3, rate)
+	p02 := LerpPoint(p01, p12, rate)
+	p13 := LerpPoint(p12, p23, rate)
+	return LerpPoint(p02, p13, rate).Value
+}
+
+// 求 a*t^3+b*t^2+c*t+d=0 的根并截断到 [0,1]，与官方 framework 的实现保持一致，二次方程也只取同一个根
+func SolveCardano(a float64, b float64, c float64, d float64) float64 {
+	if math.Abs(a) < CardanoEpsilon {
+		return Clamp(SolveQuadratic(b, c, d), 0, 1)
+	}
+	ba, ca, da := b/a, c/a, d/a
+	p := (3*ca - ba*ba) / 3
+	p3 := p / 3
+	q := (2*ba*ba*ba - 9*ba*ca + 27*da) / 27
+	q2 := q / 2
+	discriminant := q2*q2 + p3*p3*p3
+	center := ba / 3
+	inRange := func(root float64) bool {
+		return math.Abs(root-0.5) < 0.51
+	}
+	if discriminant < 0 { // 三个实根
+		mp3 := -p / 3
+		r := math.Sqrt(mp3 * mp3 * mp3)
+		phi := math.Acos(Clamp(-q/(2*r), -1, 1))
+		t1 := 2 * math.Cbrt(r)
+		root1 := t1*math.Cos(phi/3) - center
+		if inRange(root1) {
+			return Clamp(root1, 0, 1)
+		}
+		root2 := t1*math.Cos((phi+2*math.Pi)/3) - center
+		if inRange(root2) {
+			return Clamp(root2, 0, 1)
+		}
+		root3 := t1*math.Cos((phi+4*math.Pi)/3) - center
+		return Clamp(root3, 0, 1)
+	}
+	if discriminant == 0 {
+		u1 := -math.Cbrt(q2)
+		root1 := 2*u1 - center
+		if inRange(root1) {
+			return Clamp(root1, 0, 1)
+		}
+		return Clamp(-u1-center, 0, 1)
+	}
+	sd := math.Sqrt(discriminant)
+	u1 := math.Cbrt(sd - q2)
+	v1 := math.Cbrt(sd + q2)
+	return Clamp(u1-v1-center, 0, 1)
+}
+
+func SolveQuadratic(a float64, b float64, c float64) float64 {
+	if math.Abs(a) < CardanoEpsilon {
+		if math.Abs(b) < CardanoEpsilon {
+			return -c
+		}
+		return -c / b
+	}
+	return -(b + math.Sqrt(b*b-4*a*c)) / (2 * a)
+}
+
+func NewSegment(type0 int, points ...*Point) *Segment {
+	return &Segment{Points: points, Type: type0, Start: points[0].Time, End: points[len(points)-1].Time}
+}
+
+func LerpVector2(v1 Vector2, v2 Vector2, rate float32) Vector2 {
+	return Vector2{X: v1.X + (v2.X-v1.X)*rate, Y: v1.Y + (v2.Y-v1.Y)*rate}
+}
+
 func LerpPoint(p1 *Point, p2 *Point, rate float64) *Point {
 	return &Point{
 		Time:  p1.Time + rate*(p2.Time-p1.Time),
@@ -221,25 +438,21 @@ func HasFlag(flag uint8, mask uint8) bool {
 	return flag&mask > 0
 }
 
-func OpenImage(path string) *ebiten.Image {
-	res, _, err := ebitenutil.NewImageFromFile(path)
+func OpenImage(fsys fs.FS, path string) *ebiten.Image {
+	res, _, err := ebitenutil.NewImageFromFileSystem(fsys, path)
 	HandleErr(err)
 	return res
 }
 
-func OpenShader(path string) *ebiten.Shader {
-	bs := ReadFile(path)
+func OpenShader(fsys fs.FS, path string) *ebiten.Shader {
+	bs := ReadFile(fsys, path)
 	res, err := ebiten.NewShader(bs)
 	HandleErr(err)
 	return res
 }
 
-func AlignByte(data []byte, size int) []byte {
-	if len(data)%size == 0 {
-		return data
-	}
-	size -= len(data) % size
-	return append(data, make([]byte, size)...)
+func IsAligned(ptr unsafe.Pointer, align int) bool {
+	return uintptr(ptr)%uintptr(align) == 0
 }
 
 func Repeat[T any](data T, count int) []T {
@@ -249,3 +462,68 @@ func Repeat[T any](data T, count int) []T {
 	}
 	return res
 }
+
+func ToSet[T comparable](data []T) map[T]bool {
+	res := make(map[T]bool)
+	for _, item := range data {
+		res[item] = true
+	}
+	return res
+}
+
+func Clamp[T float32 | float64](value T, minValue T, maxValue T) T {
+	return min(max(value, minValue), maxValue)
+}
+
+// 循环参数超出范围的部分从另一端绕回
+func RepeatValue(value float32, minValue float32, maxValue float32) float32 {
+	size := float64(maxValue - minValue)
+	if size <= 0 {
+		return minValue
+	}
+	if value > maxValue {
+		return minValue + float32(math.Mod(float64(value-maxValue), size))
+	}
+	if value < minValue {
+		return maxValue - float32(math.Mod(float64(minValue-value), size))
+	}
+	return value
+}
+
+// 动作按权重从旧值过渡到新值，混合形状参数的值是形状权重，从默认值开始过渡，避免与上一帧的结果叠加
+func BlendParameterValue(param *Parameter, oldValue float32, value float32, weight float32) float32 {
+	if param.Type == ParameterTypeBlendShape {
+		oldValue = param.Default
+	}
+	return oldValue + weight*(value-oldValue)
+}
+
+// 表情叠加到动作结果上，覆盖模式下混合形状参数同样从默认值开始过渡
+func BlendExpressionValue(param *Parameter, oldValue float32, value float32, weight float32, blend string) float32 {
+	switch blend {
+	case BlendMultiply:
+		return oldValue * (1 + (value-1)*weight)
+	case BlendOverwrite:
+		return BlendParameterValue(param, oldValue, value, weight)
+	default: // 默认为 Add
+		return oldValue + value*weight
+	}
+}
+
+// 通过叉乘符号判断点是否在三角形内，顺时针逆时针都可以
+func InTriangle(a Vector2, b Vector2, c Vector2, x float32, y float32) bool {
+	d1 := (x-b.X)*(a.Y-b.Y) - (a.X-b.X)*(y-b.Y)
+	d2 := (x-c.X)*(b.Y-c.Y) - (b.X-c.X)*(y-c.Y)
+	d3 := (x-a.X)*(c.Y-a.Y) - (c.X-a.X)*(y-a.Y)
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNeg && hasPos)
+}
+
+func ToIndexMap(ids []string) map[string]int32 {
+	res := make(map[string]int32)
+	for i, id := range ids {
+		res[id] = int32(i)
+	}
+	return res
+}
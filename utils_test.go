@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func NewBezier(points ...[2]float64) *Segment {
+	res := make([]*Point, 0)
+	for _, point := range points {
+		res = append(res, &Point{Time: point[0], Value: point[1]})
+	}
+	return NewSegment(CurveBezier, res...)
+}
+
+type SegmentSample struct {
+	Timer float64
+	Want  float64
+}
+
+// 期望值按官方 framework（CubismMotion.cpp 的各段求值函数与 CubismMath::CardanoAlgorithmForBezier）的代码以 float32 逐步计算，
+// 这里用 float64 计算，允许 float32 的舍入误差，曲线陡峭处误差会被放大
+func CheckSegment(t *testing.T, name string, segment *Segment, restricted bool, samples []SegmentSample) {
+	curve := &Curve{BeziersRestricted: restricted, Segments: []*Segment{segment}}
+	for _, sample := range samples {
+		got, ok := GetCurveValue(curve, sample.Timer, nil)
+		if !ok || math.Abs(got-sample.Want) > 1e-4*max(1, math.Abs(sample.Want)) {
+			t.Errorf("%s %v: got %v want %v", name, sample.Timer, got, sample.Want)
+		}
+	}
+}
+
+func TestLinearSegment(t *testing.T) {
+	segment := NewSegment(CurveLinear, &Point{Time: 1, Value: 2}, &Point{Time: 3, Value: -2})
+	CheckSegment(t, "linear", segment, true, []SegmentSample{{1, 2}, {1.5, 1}, {2.2, -0.4000001}, {3, -2}})
+}
+
+func TestSteppedSegment(t *testing.T) {
+	segment := NewSegment(CurveStepped, &Point{Time: 1, Value: 2}, &Point{Time: 3, Value: -2})
+	CheckSegment(t, "stepped", segment, true, []SegmentSample{{1, 2}, {2, 2}, {2.9, 2}, {3, 2}})
+}
+
+func TestInverseSteppedSegment(t *testing.T) {
+	segment := NewSegment(CurveInverseStepped, &Point{Time: 1, Value: 2}, &Point{Time: 3, Value: -2})
+	CheckSegment(t, "inverse stepped", segment, true, []SegmentSample{{1, -2}, {1.1, -2}, {2, -2}, {3, -2}})
+}
+
+// AreBeziersRestricted 为 true 时 t 与时间线性对应
+func TestBezierSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment *Segment
+		samples []SegmentSample
+	}{
+		{"ease in", NewBezier([2]float64{0, 0}, [2]float64{0.6, 0}, [2]float64{0.9, 1}, [2]float64{1, 1}),
+			[]SegmentSample{{0.1, 0.028}, {0.25, 0.15625}, {0.5, 0.5}, {0.75, 0.84375}, {0.9, 0.972}}},
+		{"ease out", NewBezier([2]float64{0, 0}, [2]float64{0.05, 0.8}, [2]float64{0.4, 1}, [2]float64{1, 1}),
+			[]SegmentSample{{0.1, 0.22240001}, {0.25, 0.49375004}, {0.5, 0.79999995}, {0.75, 0.95625}, {0.9, 0.9936}}},
+		{"offset", NewBezier([2]float64{1, -30}, [2]float64{1.2, 10}, [2]float64{1.3, 30}, [2]float64{2, 0}),
+			[]SegmentSample{{1.1, -18.629997}, {1.25, -4.21875}, {1.5, 11.25}, {1.75, 13.59375}, {1.9, 7.5300007}}},
+		{"quadratic", NewBezier([2]float64{0, 0}, [2]float64{0.2, 1}, [2]float64{1.6 / 3, -1}, [2]float64{1, 0.5}),
+			[]SegmentSample{{0.1, 0.2165}, {0.25, 0.2890625}, {0.5, 0.0625}, {0.75, -0.0703125}, {0.9, 0.1484999}}},
+	}
+	for _, test := range tests {
+		CheckSegment(t, test.name, test.segment, true, test.samples)
+	}
+}
+
+// 控制点时间在三等分处时三次与二次项都为 0，走一次方程；quadratic 的三次项为 0，near eps 的三次项小于 CardanoEpsilon
+// 官方的二次方程只取 -(b+sqrt(b*b-4ac))/2a 这一个根，quadratic 这种情况解出的 t 被截断为 0，结果都是起点的值
+func TestBezierCardanoSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment *Segment
+		samples []SegmentSample
+	}{
+		{"ease in", NewBezier([2]float64{0, 0}, [2]float64{0.6, 0}, [2]float64{0.9, 1}, [2]float64{1, 1}),
+			[]SegmentSample{{0.1, 0.009434805}, {0.25, 0.060706124}, {0.5, 0.25527164}, {0.75, 0.6007594}, {0.9, 0.8691426}}},
+		{"ease out", NewBezier([2]float64{0, 0}, [2]float64{0.05, 0.8}, [2]float64{0.4, 1}, [2]float64{1, 1}),
+			[]SegmentSample{{0.1, 0.5119921}, {0.25, 0.75718427}, {0.5, 0.9245023}, {0.75, 0.985537}, {0.9, 0.9979831}}},
+		{"offset", NewBezier([2]float64{1, -30}, [2]float64{1.2, 10}, [2]float64{1.3, 30}, [2]float64{2, 0}),
+			[]SegmentSample{{1.1, -10.923321}, {1.25, 7.522706}, {1.5, 14.38399}, {1.75, 9.415049}, {1.9, 4.105789}}},
+		{"linear", NewBezier([2]float64{0, 0}, [2]float64{1.0 / 3, 2}, [2]float64{2.0 / 3, -1}, [2]float64{1, 1}),
+			[]SegmentSample{{0.1, 0.46000004}, {0.25, 0.71875}, {0.5, 0.5}, {0.75, 0.28125}, {0.9, 0.53999996}}},
+		{"quadratic", NewBezier([2]float64{0, 0}, [2]float64{0.2, 1}, [2]float64{1.6 / 3, -1}, [2]float64{1, 0.5}),
+			[]SegmentSample{{0.1, 0}, {0.25, 0}, {0.5, 0}, {0.75, 0}, {0.9, 0}}},
+		{"near eps", NewBezier([2]float64{0, 0}, [2]float64{0.2, 1}, [2]float64{(1.6 - 5e-6) / 3, -1}, [2]float64{1, 0.5}),
+			[]SegmentSample{{0.1, 0}, {0.25, 0}, {0.5, 0}, {0.75, 0}, {0.9, 0}}},
+	}
+	for _, test := range tests {
+		CheckSegment(t, test.name, test.segment, false, test.samples)
+	}
+}
+
+func TestSolveCardano(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, c, d float64
+		want       float64
+	}{
+		{"three roots", 1, -1.3, -1.7, 0.6, 0.3}, // (t-0.3)(t-2)(t+1)
+		{"one root", 1, 0, 1, -0.5, 0.4238537990697832},
+		{"double root", 1, 0, -0.75, 0.25, 0.5}, // (t-0.5)^2(t+1)
+		{"quadratic", 0, 1, 0, -0.25, 0},        // 只取 -(b+sqrt)/2a 这个根，-0.5 截断为 0
+		{"linear", 0, 0, 2, -1, 0.5},
+		{"constant", 0, 0, 0, -0.5, 0.5},
+		{"clamp", 0, 0, 1, -2, 1},
+	}
+	for _, test := range tests {
+		if got := SolveCardano(test.a, test.b, test.c, test.d); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// 曲线从 0 到 Duration，偶尔有长度为 0 的段，[0, Duration] 内的每个时间都恰好属于一段，之外的不属于任何段
+// 期望的段按 0 与 Duration 之间不大于 timer 的分界点数目计算，长度为 0 的段被跳过，结尾属于最后一段
+func FuzzFindSegment(f *testing.F) {
+	f.Add(int64(1), 0.0, 0)
+	f.Add(int64(2), 0.5, 3)
+	f.Add(int64(3), 1.0, -1)
+	f.Add(int64(4), 100.0, 100)
+	f.Fuzz(func(t *testing.T, seed int64, rate float64, cursor int) {
+		if math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return
+		}
+		r := rand.New(rand.NewSource(seed))
+		times := []float64{0}
+		for i := 1 + r.Intn(20); i > 0; i-- {
+			time := times[len(times)-1]
+			if r.Intn(5) > 0 { // 偶尔出现长度为 0 的段，包括开头与结尾
+				time += r.Float64()
+			}
+			times = append(times, time)
+		}
+		segments := make([]*Segment, 0)
+		for i := 1; i < len(times); i++ {
+			segments = append(segments, NewSegment(CurveLinear, &Point{Time: times[i-1]}, &Point{Time: times[i]}))
+		}
+		duration := times[len(times)-1]
+		timers := append([]float64{duration * math.Mod(math.Abs(rate), 1), duration}, times...)
+		for _, timer := range timers {
+			want := 0
+			for _, time := range times[1 : len(times)-1] {
+				if time <= timer {
+					want++
+				}
+			}
+			got := FindSegment(segments, timer, cursor)
+			if got != want {
+				t.Fatalf("timer %v cursor %d in %v: got %d want %d", timer, cursor, times, got, want)
+			}
+			if segment := segments[got]; timer < segment.Start || timer > segment.End {
+				t.Fatalf("timer %v outside segment %d [%v, %v]", timer, got, segment.Start, segment.End)
+			}
+		}
+		for _, timer := range []float64{math.Nextafter(0, -1), math.Nextafter(duration, math.Inf(1)), -math.Abs(rate) - 1, duration + math.Abs(rate) + 1} {
+			if got := FindSegment(segments, timer, cursor); got != -1 {
+				t.Fatalf("timer %v outside [0, %v]: got %d", timer, duration, got)
+			}
+		}
+	})
+}
+
+// FS 的根目录是 model3.json 与引用的文件共同的上级目录，访问不到更上层的文件
+func TestOpenModelFS(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"secret.txt":                  "secret",
+		"lib/shared/tex.png":          "tex",
+		"lib/models/a/a.model3.json":  `{"FileReferences": {"Moc": "a.moc3", "Textures": ["../../shared/tex.png"]}}`,
+		"lib/models/b/b.model3.json":  `{"FileReferences": {"Moc": "b.moc3", "Motions": {"Idle": [{"File": "motions/idle.motion3.json", "Sound": "../sounds/idle.wav"}]}}}`,
+		"lib/models/c/c.model3.json":  `{"FileReferences": {"Moc": "c.moc3"}}`,
+		"lib/models/d/d.model3.json":  `not json`,
+		"lib/models/sounds/idle.wav":  "wav",
+		"lib/models/b/motions/x.json": "{}",
+	}
+	for file, data := range files {
+		file = filepath.Join(root, filepath.FromSlash(file))
+		HandleErr(os.MkdirAll(filepath.Dir(file), 0755))
+		HandleErr(os.WriteFile(file, []byte(data), 0644))
+	}
+	tests := []struct {
+		path string
+		name string
+		read string
+	}{
+		{"lib/models/a/a.model3.json", "models/a/a.model3.json", "shared/tex.png"},
+		{"lib/models/b/b.model3.json", "b/b.model3.json", "sounds/idle.wav"},
+		{"lib/models/c/c.model3.json", "c.model3.json", "c.model3.json"},
+		{"lib/models/d/d.model3.json", "d.model3.json", "d.model3.json"}, // 解析失败时只用所在的目录
+	}
+	for _, test := range tests {
+		fsys, name := OpenModelFS(filepath.Join(root, filepath.FromSlash(test.path)))
+		if name != test.name {
+			t.Errorf("%s: name %s want %s", test.path, name, test.name)
+		}
+		if _, err := fs.ReadFile(fsys, test.read); err != nil {
+			t.Errorf("%s: %v", test.path, err)
+		}
+		for _, file := range []string{"secret.txt", "../secret.txt", "../../secret.txt", "../../../secret.txt"} {
+			if _, err := fs.ReadFile(fsys, file); err == nil {
+				t.Errorf("%s: %s is readable", test.path, file)
+			}
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	LevelError = "error"
+	LevelWarn  = "warn"
+)
+
+type Problem struct {
+	Level string
+	File  string
+	Path  string // 出问题的 json 路径
+	Msg   string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("[%s] %s: %s: %s", p.Level, p.File, p.Path, p.Msg)
+}
+
+// 校验模型引用的所有资源，收集全部问题而不是遇到第一个就 panic
+type Validator struct {
+	FS           fs.FS
+	Dir          string // model3.json 在 FS 中所在的目录
+	Problems     []*Problem
+	ParameterIds map[string]bool // moc 加载失败时为 nil，跳过 id 校验
+	PartIds      map[string]bool
+	DrawableIds  map[string]bool
+}
+
+// 与 LoadModel 一样支持普通路径与 .zip
+func ValidateModel(path string) []*Problem {
+	fsys, name := OpenModelFS(path)
+	if closer, ok := fsys.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return ValidateModelFS(fsys, name)
+}
+
+func ValidateModelFS(fsys fs.FS, name string) []*Problem {
+	v := &Validator{FS: fsys, Dir: path.Dir(name)}
+	v.Validate(name)
+	return v.Problems
+}
+
+func (v *Validator) Report(level string, file string, path string, msg string, args ...any) {
+	v.Problems = append(v.Problems, &Problem{Level: level, File: file, Path: path, Msg: fmt.Sprintf(msg, args...)})
+}
+
+func (v *Validator) Validate(name string) {
+	modelData := &ModelData{}
+	if !v.Unmarshal(name, "$", modelData) {
+		return
+	}
+	ref := modelData.FileReferences
+	if ref == nil {
+		v.Report(LevelError, name, "$.FileReferences", "missing")
+		return
+	}
+	v.ValidateMoc(name, ref)
+	v.ValidateTextures(name, ref)
+	for i, group := range modelData.Groups {
+		v.ValidateIds(name, fmt.Sprintf("$.Groups[%d]", i), group.Target, group.Ids)
+	}
+	for i, hitArea := range modelData.HitAreas {
+		if v.DrawableIds != nil && !v.DrawableIds[hitArea.Id] {
+			v.Report(LevelError, name, fmt.Sprintf("$.HitAreas[%d].Id", i), "drawable %s not found", hitArea.Id)
+		}
+	}
+	if len(ref.Physics) > 0 {
+		v.ValidatePhysics(name, ref.Physics)
+	}
+	if len(ref.Pose) > 0 {
+		v.ValidatePose(name, ref.Pose)
+	}
+	if len(ref.DisplayInfo) > 0 {
+		v.ValidateDisplay(name, ref.DisplayInfo)
+	}
+	if len(ref.UserData) > 0 {
+		v.ValidateFile(name, "$.FileReferences.UserData", ref.UserData)
+	}
+	for i, item := range ref.Expressions {
+		v.ValidateExpression(name, fmt.Sprintf("$.FileReferences.Expressions[%d].File", i), item.File)
+	}
+	for group, motions := range ref.Motions {
+		for i, motion := range motions {
+			jsonPath := fmt.Sprintf("$.FileReferences.Motions.%s[%d]", group, i)
+			if len(motion.Sound) > 0 {
+				v.ValidateFile(name, jsonPath+".Sound", motion.Sound)
+			}
+			v.ValidateMotion(name, jsonPath+".File", motion.File)
+		}
+	}
+}
+
+// 校验引用的文件是否存在，file 为相对模型目录的路径
+func (v *Validator) ValidateFile(src string, jsonPath string, file string) bool {
+	if len(file) == 0 {
+		v.Report(LevelError, src, jsonPath, "empty file reference")
+		return false
+	}
+	if _, err := fs.Stat(v.FS, path.Join(v.Dir, file)); err != nil {
+		v.Report(LevelError, src, jsonPath, "file %s not found", file)
+		return false
+	}
+	return true
+}
+
+func (v *Validator) Unmarshal(file string, jsonPath string, dst any) bool {
+	bs, err := fs.ReadFile(v.FS, file)
+	if err != nil {
+		v.Report(LevelError, file, jsonPath, "%v", err)
+		return false
+	}
+	if err = json.Unmarshal(bs, dst); err != nil {
+		v.Report(LevelError, file, jsonPath, "%v", err)
+		return false
+	}
+	return true
+}
+
+func (v *Validator) ValidateMoc(src string, ref *FileReferencesData) {
+	if !v.ValidateFile(src, "$.FileReferences.Moc", ref.Moc) {
+		return
+	}
+	file := path.Join(v.Dir, ref.Moc)
+	defer func() { // LoadMoc 使用 panic 报告错误
+		if err := recover(); err != nil {
+			v.ParameterIds, v.PartIds, v.DrawableIds = nil, nil, nil
+			v.Report(LevelError, file, "$", "%v", err)
+		}
+	}()
+	moc := LoadMoc(ReadFile(v.FS, file))
+	defer moc.Release() // 只用来读取 id 与纹理索引
+	v.ParameterIds = ToSet(GetParameterIds(moc.Model))
+	v.PartIds = ToSet(GetPartIds(moc.Model))
+	v.DrawableIds = ToSet(GetDrawableIds(moc.Model))
+	maxIdx := int32(-1)
+	for _, idx := range GetDrawableTextureIndices(moc.Model) {
+		maxIdx = max(maxIdx, idx)
+	}
+	if int(maxIdx) >= len(ref.Textures) {
+		v.Report(LevelError, src, "$.FileReferences.Textures", "moc uses texture index %d but only %d textures", maxIdx, len(ref.Textures))
+	} else if int(maxIdx)+1 < len(ref.Textures) {
+		v.Report(LevelWarn, src, "$.FileReferences.Textures", "moc uses %d textures but %d declared", maxIdx+1, len(ref.Textures))
+	}
+}
+
+func (v *Validator) ValidateTextures(src string, ref *FileReferencesData) {
+	for i, texture := range ref.Textures {
+		v.ValidateFile(src, fmt.Sprintf("$.FileReferences.Textures[%d]", i), texture)
+	}
+}
+
+// 校验 id 是否存在于 moc 中，target 取 Parameter 或 PartOpacity
+func (v *Validator) ValidateIds(file string, jsonPath string, target string, ids []string) {
+	for i, id := range ids {
+		v.ValidateId(file, fmt.Sprintf("%s.Ids[%d]", jsonPath, i), target, id)
+	}
+}
+
+func (v *Validator) ValidateId(file string, jsonPath string, target string, id string) {
+	switch target {
+	case TargetParameter:
+		if v.ParameterIds != nil && !v.ParameterIds[id] {
+			v.Report(LevelError, file, jsonPath, "parameter %s not found", id)
+		}
+	case TargetPartOpacity, "Part":
+		if v.PartIds != nil && !v.PartIds[id] {
+			v.Report(LevelError, file, jsonPath, "part %s not found", id)
+		}
+	case TargetModel: // 模型级别的曲线 Opacity EyeBlink LipSync 不对应 moc 中的 id
+	default:
+		v.Report(LevelError, file, jsonPath, "invalid target %s", target)
+	}
+}
+
+func (v *Validator) ValidatePhysics(src string, file string) {
+	if !v.ValidateFile(src, "$.FileReferences.Physics", file) {
+		return
+	}
+	file = path.Join(v.Dir, file)
+	physicData := &PhysicData{}
+	if !v.Unmarshal(file, "$", physicData) {
+		return
+	}
+	for i, setting := range physicData.PhysicsSettings {
+		for j, input := range setting.Input {
+			if input.Source == nil {
+				v.Report(LevelError, file, fmt.Sprintf("$.PhysicsSettings[%d].Input[%d].Source", i, j), "missing")
+				continue
+			}
+			v.ValidateId(file, fmt.Sprintf("$.PhysicsSettings[%d].Input[%d].Source.Id", i, j), input.Source.Target, input.Source.Id)
+		}
+		for j, output := range setting.Output {
+			if output.Destination == nil {
+				v.Report(LevelError, file, fmt.Sprintf("$.PhysicsSettings[%d].Output[%d].Destination", i, j), "missing")
+				continue
+			}
+			v.ValidateId(file, fmt.Sprintf("$.PhysicsSettings[%d].Output[%d].Destination.Id", i, j), output.Destination.Target, output.Destination.Id)
+			if output.VertexIndex < 0 || output.VertexIndex >= len(setting.Vertices) {
+				v.Report(LevelError, file, fmt.Sprintf("$.PhysicsSettings[%d].Output[%d].VertexIndex", i, j), "vertex index %d out of range", output.VertexIndex)
+			}
+		}
+	}
+}
+
+func (v *Validator) ValidatePose(src string, file string) {
+	if !v.ValidateFile(src, "$.FileReferences.Pose", file) {
+		return
+	}
+	file = path.Join(v.Dir, file)
+	poseData := &PoseData{}
+	if !v.Unmarshal(file, "$", poseData) {
+		return
+	}
+	for i, group := range poseData.Groups {
+		for j, item := range group {
+			jsonPath := fmt.Sprintf("$.Groups[%d][%d]", i, j)
+			v.ValidateId(file, jsonPath+".Id", TargetPartOpacity, item.Id)
+			for k, link := range item.Link {
+				v.ValidateId(file, fmt.Sprintf("%s.Link[%d]", jsonPath, k), TargetPartOpacity, link)
+			}
+		}
+	}
+}
+
+func (v *Validator) ValidateDisplay(src string, file string) {
+	if !v.ValidateFile(src, "$.FileReferences.DisplayInfo", file) {
+		return
+	}
+	file = path.Join(v.Dir, file)
+	displayData := &DisplayData{}
+	if !v.Unmarshal(file, "$", displayData) {
+		return
+	}
+	for i, item := range displayData.Parameters {
+		v.ValidateId(file, fmt.Sprintf("$.Parameters[%d].Id", i), TargetParameter, item.Id)
+	}
+	for i, item := range displayData.Parts {
+		v.ValidateId(file, fmt.Sprintf("$.Parts[%d].Id", i), TargetPartOpacity, item.Id)
+	}
+}
+
+func (v *Validator) ValidateExpression(src string, jsonPath string, file string) {
+	if !v.ValidateFile(src, jsonPath, file) {
+		return
+	}
+	file = path.Join(v.Dir, file)
+	bs, err := fs.ReadFile(v.FS, file)
+	if err != nil {
+		v.Report(LevelError, file, "$", "%v", err)
+		return
+	}
+	expressionData, err := ParseExpression(bs, file)
+	if err != nil {
+		v.Report(LevelError, file, "$", "%v", err)
+		return
+	}
+	for i, item := range expressionData.Parameters {
+		v.ValidateId(file, fmt.Sprintf("$.Parameters[%d].Id", i), TargetParameter, item.Id)
+	}
+}
+
+func (v *Validator) ValidateMotion(src string, jsonPath string, file string) {
+	if !v.ValidateFile(src, jsonPath, file) {
+		return
+	}
+	file = path.Join(v.Dir, file)
+	if strings.HasSuffix(file, MtnExt) {
+		v.ValidateMtn(file)
+		return
+	}
+	motionData := &MotionData1{}
+	if !v.Unmarshal(file, "$", motionData) {
+		return
+	}
+	if motionData.Meta == nil {
+		v.Report(LevelError, file, "$.Meta", "missing")
+		return
+	}
+	segmentCount, pointCount := 0, 0
+	for i, curve := range motionData.Curves {
+		curvePath := fmt.Sprintf("$.Curves[%d]", i)
+		v.ValidateId(file, curvePath+".Id", curve.Target, curve.Id)
+		segments, points := v.ValidateSegments(file, curvePath+".Segments", curve.Segments, motionData.Meta.Duration)
+		segmentCount += segments
+		pointCount += points
+	}
+	userDataSize := 0
+	for _, item := range motionData.UserData {
+		userDataSize += len(item.Value)
+	}
+	meta := motionData.Meta
+	v.ValidateCount(file, "$.Meta.CurveCount", meta.CurveCount, len(motionData.Curves))
+	v.ValidateCount(file, "$.Meta.TotalSegmentCount", meta.TotalSegmentCount, segmentCount)
+	v.ValidateCount(file, "$.Meta.TotalPointCount", meta.TotalPointCount, pointCount)
+	v.ValidateCount(file, "$.Meta.UserDataCount", meta.UserDataCount, len(motionData.UserData))
+	v.ValidateCount(file, "$.Meta.TotalUserDataSize", meta.TotalUserDataSize, userDataSize)
+}
+
+// Cubism 2 的动作没有 Meta，只检查 Id
+func (v *Validator) ValidateMtn(file string) {
+	bs, err := fs.ReadFile(v.FS, file)
+	if err != nil {
+		v.Report(LevelError, file, "$", "%v", err)
+		return
+	}
+	motionData, err := ParseMtn(bs)
+	if err != nil {
+		v.Report(LevelError, file, "$", "%v", err)
+		return
+	}
+	for _, curve := range motionData.Curves {
+		v.ValidateId(file, curve.Id, curve.Target, curve.Id)
+	}
+}
+
+// 官方运行时按 Meta 中的数目预分配内存，偏小会越界，偏大只是浪费
+func (v *Validator) ValidateCount(file string, jsonPath string, count int, real int) {
+	if count < real {
+		v.Report(LevelError, file, jsonPath, "count %d less than real %d", count, real)
+	} else if count > real {
+		v.Report(LevelWarn, file, jsonPath, "count %d greater than real %d", count, real)
+	}
+}
+
+// 返回段数与点数，点数按官方规则统计：起始点 1 个，Bezier 3 个，其余 1 个
+func (v *Validator) ValidateSegments(file string, jsonPath string, segments []float64, duration float64) (int, int) {
+	if len(segments) < 2 {
+		v.Report(LevelError, file, jsonPath, "need start point but got %d values", len(segments))
+		return 0, 0
+	}
+	segmentCount, pointCount := 0, 1
+	lastTime := segments[0]
+	if lastTime < 0 {
+		v.Report(LevelError, file, jsonPath+"[0]", "negative time %v", lastTime)
+	}
+	i := 2
+	for i < len(segments) {
+		typePath := fmt.Sprintf("%s[%d]", jsonPath, i)
+		type0 := segments[i]
+		i++
+		size := 2
+		switch type0 {
+		case CurveLinear, CurveStepped, CurveInverseStepped:
+		case CurveBezier:
+			size = 6
+		default:
+			v.Report(LevelError, file, typePath, "invalid segment type %v", type0)
+			return segmentCount, pointCount
+		}
+		if i+size > len(segments) {
+			v.Report(LevelError, file, typePath, "segment needs %d values but only %d left", size, len(segments)-i)
+			return segmentCount, pointCount
+		}
+		for j := 0; j < size; j += 2 { // 控制点与结束点时间都不能早于上一个点
+			if segments[i+j] < lastTime {
+				v.Report(LevelError, file, fmt.Sprintf("%s[%d]", jsonPath, i+j), "time %v before %v", segments[i+j], lastTime)
+			}
+			lastTime = segments[i+j]
+		}
+		segmentCount++
+		pointCount += size / 2
+		i += size
+	}
+	if lastTime > duration {
+		v.Report(LevelWarn, file, jsonPath, "end time %v exceeds duration %v", lastTime, duration)
+	}
+	return segmentCount, pointCount
+}
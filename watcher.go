@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// 通过轮询文件修改时间发现改动，zip 等只读文件系统中修改时间不会变
+type Watcher struct {
+	FS       fs.FS
+	Interval float64 // 轮询间隔，单位秒
+	Timer    float64
+	ModTimes map[string]time.Time
+}
+
+func NewWatcher(fsys fs.FS, files []string, interval float64) *Watcher {
+	res := &Watcher{FS: fsys, Interval: interval, ModTimes: make(map[string]time.Time)}
+	for _, file := range files {
+		res.ModTimes[file] = res.GetModTime(file)
+	}
+	return res
+}
+
+func (w *Watcher) GetModTime(file string) time.Time {
+	info, err := fs.Stat(w.FS, file)
+	if err != nil { // 编辑器保存时可能短暂不存在，当作没有修改
+		return w.ModTimes[file]
+	}
+	return info.ModTime()
+}
+
+// 返回距离上次轮询有修改的文件
+func (w *Watcher) Update(delta float64) []string {
+	w.Timer += delta
+	if w.Timer < w.Interval {
+		return nil
+	}
+	w.Timer = 0
+	res := make([]string, 0)
+	for file, modTime := range w.ModTimes {
+		if curr := w.GetModTime(file); !curr.Equal(modTime) {
+			w.ModTimes[file] = curr
+			res = append(res, file)
+		}
+	}
+	return res
+}